@@ -0,0 +1,404 @@
+package arping
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcap"
+	"github.com/pkg/errors"
+
+	"github.com/joyme123/gnt/utils"
+)
+
+var (
+	// snaplen is number of bytes max to read per packet, an arp frame is 42 bytes without padding
+	snaplen = 128
+	// readTimeout is the timeout of reading a packet, so that the reader checks whether arping is done
+	readTimeout = 100 * time.Millisecond
+	// defaultInterval is the default time between sending each packet
+	defaultInterval = time.Second
+
+	// zeroMAC is the unknown target mac address of arp request
+	zeroMAC = net.HardwareAddr{0, 0, 0, 0, 0, 0}
+)
+
+// Reply is an arp packet sent by target in response to the probes
+type Reply struct {
+	// IP is the sender ip address, which is the target
+	IP net.IP
+	// HardwareAddr is the sender mac address
+	HardwareAddr net.HardwareAddr
+	// RTT is the time since the last probe was sent
+	RTT time.Duration
+	// Request is true if the packet is an arp request instead of reply
+	Request bool
+	// Broadcast is true if the packet is sent to the broadcast address
+	Broadcast bool
+
+	receivedAt time.Time
+}
+
+// Statistics is the statistics of arping
+type Statistics struct {
+	// Target is the target ip address
+	Target string
+	// Interface is the interface sending packets
+	Interface string
+	// Sent is the number of packets sent
+	Sent int
+	// BroadcastSent is the number of packets sent to the broadcast address
+	BroadcastSent int
+	// Received is the number of replies received
+	Received int
+	// RequestsReceived is the number of arp requests received, e.g. the probes of target
+	// detecting duplicate address in DAD mode
+	RequestsReceived int
+	// BroadcastsReceived is the number of replies sent to the broadcast address
+	BroadcastsReceived int
+	// Replies are the replies in receiving order
+	Replies []Reply
+}
+
+type Arping struct {
+	Target      string
+	Interface   string
+	Source      string
+	Count       int
+	Interval    time.Duration
+	Deadline    time.Duration
+	Quit        bool
+	Broadcast   bool
+	DAD         bool
+	Unsolicited bool
+	Advert      bool
+
+	intf     *net.Interface
+	targetIP net.IP
+	sourceIP net.IP
+	// dstMAC is the destination of probes, it's the broadcast address until target replied
+	dstMAC   net.HardwareAddr
+	lastSent time.Time
+	stats    Statistics
+
+	logger logr.Logger
+}
+
+func NewArping(opt *Options, target string, logger logr.Logger) *Arping {
+	a := &Arping{
+		Target:      target,
+		Interface:   opt.Interface,
+		Source:      opt.Source,
+		Count:       opt.Count,
+		Interval:    opt.Interval,
+		Deadline:    opt.Deadline,
+		Quit:        opt.Quit,
+		Broadcast:   opt.Broadcast,
+		DAD:         opt.DAD,
+		Unsolicited: opt.Unsolicited,
+		Advert:      opt.Advert,
+		logger:      logger,
+	}
+	if a.Interval == 0 {
+		a.Interval = defaultInterval
+	}
+	return a
+}
+
+// Run sends arp packets until the context is done, Count packets are sent or the deadline
+// exceeded, and returns the statistics.
+func (a *Arping) Run(ctx context.Context) (*Statistics, error) {
+	if err := a.init(); err != nil {
+		return nil, err
+	}
+	handle, err := a.openLive()
+	if err != nil {
+		return nil, err
+	}
+	defer handle.Close()
+
+	if a.Deadline > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, a.Deadline)
+		defer cancel()
+	}
+	fmt.Printf("ARPING %s from %s %s\n", a.targetIP, a.sourceIP, a.intf.Name)
+
+	// no reply is expected for unsolicited packets, and the reader must exit before the
+	// handle is closed
+	replies := make(chan *Reply)
+	errc := make(chan error, 1)
+	readCtx, stop := context.WithCancel(ctx)
+	done := make(chan struct{})
+	if a.announcing() {
+		close(done)
+	} else {
+		go func() {
+			defer close(done)
+			if err := a.receive(readCtx, handle, replies); err != nil {
+				errc <- err
+			}
+		}()
+	}
+	defer func() {
+		stop()
+		<-done
+	}()
+
+	ticker := time.NewTicker(a.Interval)
+	defer ticker.Stop()
+	if err := a.send(handle); err != nil {
+		return &a.stats, err
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return &a.stats, nil
+		case err := <-errc:
+			return &a.stats, err
+		case reply := <-replies:
+			if a.processReply(reply) {
+				return &a.stats, nil
+			}
+		case <-ticker.C:
+			// with deadline, it keeps sending until Count replies are received
+			if a.Count > 0 && a.stats.Sent >= a.Count && (a.Deadline == 0 || a.announcing()) {
+				return &a.stats, nil
+			}
+			if err := a.send(handle); err != nil {
+				return &a.stats, err
+			}
+		}
+	}
+}
+
+// announcing returns true for unsolicited and advert modes
+func (a *Arping) announcing() bool {
+	return a.Unsolicited || a.Advert
+}
+
+func (a *Arping) init() error {
+	if a.DAD && a.announcing() {
+		return fmt.Errorf("duplicate address detection can't be used with unsolicited arp")
+	}
+	addr, err := net.ResolveIPAddr("ip4", a.Target)
+	if err != nil {
+		return err
+	}
+	a.targetIP = addr.IP.To4()
+	a.stats.Target = a.targetIP.String()
+
+	if a.Interface == "" {
+		route, err := utils.LookupRoute(a.targetIP, nil)
+		if err != nil {
+			return err
+		}
+		if route.Interface == nil {
+			return fmt.Errorf("no interface found to reach %s, specify it by -I", a.targetIP)
+		}
+		a.intf = route.Interface
+	} else if a.intf, err = net.InterfaceByName(a.Interface); err != nil {
+		return err
+	}
+	if len(a.intf.HardwareAddr) != len(zeroMAC) {
+		return fmt.Errorf("interface %s is not an ethernet device", a.intf.Name)
+	}
+	a.stats.Interface = a.intf.Name
+
+	switch {
+	case a.announcing():
+		// the address of interface is announced
+		a.sourceIP = a.targetIP
+	case a.Source != "":
+		if a.sourceIP = net.ParseIP(a.Source).To4(); a.sourceIP == nil {
+			return fmt.Errorf("invalid ipv4 source address %q", a.Source)
+		}
+	case a.DAD:
+		a.sourceIP = net.IPv4zero.To4()
+	default:
+		src, err := utils.SourceAddr(a.targetIP, a.intf.Name)
+		if err != nil {
+			return err
+		}
+		a.sourceIP = src.To4()
+	}
+	a.dstMAC = layers.EthernetBroadcast
+	return nil
+}
+
+func (a *Arping) openLive() (*pcap.Handle, error) {
+	inactive, err := pcap.NewInactiveHandle(a.intf.Name)
+	if err != nil {
+		return nil, errors.Wrap(err, "create pcap handle failed")
+	}
+	defer inactive.CleanUp()
+
+	if err := inactive.SetSnapLen(snaplen); err != nil {
+		return nil, errors.Wrap(err, "set snaplen failed")
+	}
+	if err := inactive.SetTimeout(readTimeout); err != nil {
+		return nil, errors.Wrap(err, "set timeout failed")
+	}
+	// deliver the replies as soon as they arrive instead of buffering them
+	if err := inactive.SetImmediateMode(true); err != nil {
+		return nil, errors.Wrap(err, "set immediate mode failed")
+	}
+	handle, err := inactive.Activate()
+	if err != nil {
+		return nil, errors.Wrap(err, "open live failed")
+	}
+	if err := handle.SetBPFFilter("arp"); err != nil {
+		handle.Close()
+		return nil, errors.Wrap(err, "set bpf filter failed")
+	}
+	return handle, nil
+}
+
+// send sends an arp request, or a gratuitous arp reply in advert mode
+func (a *Arping) send(handle *pcap.Handle) error {
+	op, targetMAC := uint16(layers.ARPRequest), zeroMAC
+	if a.Advert {
+		op, targetMAC = layers.ARPReply, a.intf.HardwareAddr
+	}
+	frame, err := arpFrame(op, a.intf.HardwareAddr, a.dstMAC, a.sourceIP, targetMAC, a.targetIP)
+	if err != nil {
+		return err
+	}
+	// the reply may arrive before writing returns
+	a.lastSent = time.Now()
+	if err := handle.WritePacketData(frame); err != nil {
+		return errors.Wrap(err, "send arp packet failed")
+	}
+
+	a.stats.Sent++
+	if bytes.Equal(a.dstMAC, layers.EthernetBroadcast) {
+		a.stats.BroadcastSent++
+	}
+	return nil
+}
+
+// arpFrame builds an ethernet frame of arp packet
+func arpFrame(op uint16, srcMAC, dstMAC net.HardwareAddr, senderIP net.IP, targetMAC net.HardwareAddr, targetIP net.IP) ([]byte, error) {
+	eth := layers.Ethernet{
+		SrcMAC:       srcMAC,
+		DstMAC:       dstMAC,
+		EthernetType: layers.EthernetTypeARP,
+	}
+	arp := layers.ARP{
+		AddrType:          layers.LinkTypeEthernet,
+		Protocol:          layers.EthernetTypeIPv4,
+		HwAddressSize:     6,
+		ProtAddressSize:   4,
+		Operation:         op,
+		SourceHwAddress:   srcMAC,
+		SourceProtAddress: senderIP.To4(),
+		DstHwAddress:      targetMAC,
+		DstProtAddress:    targetIP.To4(),
+	}
+
+	buf := gopacket.NewSerializeBuffer()
+	if err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{FixLengths: true}, &eth, &arp); err != nil {
+		return nil, errors.Wrap(err, "build arp packet failed")
+	}
+	return buf.Bytes(), nil
+}
+
+// receive reads the replies of target until the context is done
+func (a *Arping) receive(ctx context.Context, handle *pcap.Handle, replies chan<- *Reply) error {
+	for ctx.Err() == nil {
+		data, ci, err := handle.ReadPacketData()
+		if err == pcap.NextErrorTimeoutExpired {
+			continue
+		}
+		if err != nil {
+			return errors.Wrap(err, "read packet failed")
+		}
+
+		reply := a.parseReply(data)
+		if reply == nil {
+			continue
+		}
+		reply.receivedAt = ci.Timestamp
+		select {
+		case replies <- reply:
+		case <-ctx.Done():
+		}
+	}
+	return nil
+}
+
+// parseReply parses the frame and returns the reply of target, it's nil if the frame
+// doesn't answer the probes. Like iputils, the sender of reply must be target and the
+// replies of our own mac address are ignored. A reply must be sent to the source
+// address, but in DAD mode, any packet from target means that the address is in use.
+func (a *Arping) parseReply(data []byte) *Reply {
+	packet := gopacket.NewPacket(data, layers.LayerTypeEthernet, gopacket.NoCopy)
+	ethLayer, arpLayer := packet.Layer(layers.LayerTypeEthernet), packet.Layer(layers.LayerTypeARP)
+	if ethLayer == nil || arpLayer == nil {
+		return nil
+	}
+	eth, arp := ethLayer.(*layers.Ethernet), arpLayer.(*layers.ARP)
+	if arp.AddrType != layers.LinkTypeEthernet || arp.Protocol != layers.EthernetTypeIPv4 ||
+		arp.HwAddressSize != 6 || arp.ProtAddressSize != 4 {
+		return nil
+	}
+	if arp.Operation != layers.ARPRequest && arp.Operation != layers.ARPReply {
+		return nil
+	}
+
+	sender, target := net.IP(arp.SourceProtAddress), net.IP(arp.DstProtAddress)
+	if !sender.Equal(a.targetIP) || bytes.Equal(arp.SourceHwAddress, a.intf.HardwareAddr) {
+		return nil
+	}
+	if a.DAD {
+		// the probe of sender ip 0.0.0.0 may be answered by a broadcast request
+		if !a.sourceIP.IsUnspecified() && !target.Equal(a.sourceIP) {
+			return nil
+		}
+	} else if !target.Equal(a.sourceIP) || !bytes.Equal(arp.DstHwAddress, a.intf.HardwareAddr) {
+		a.logger.V(4).Info("arp packet not sent to us", "sender", sender.String(), "target", target.String())
+		return nil
+	}
+
+	return &Reply{
+		IP:           append(net.IP(nil), sender...),
+		HardwareAddr: append(net.HardwareAddr(nil), arp.SourceHwAddress...),
+		Request:      arp.Operation == layers.ARPRequest,
+		Broadcast:    bytes.Equal(eth.DstMAC, layers.EthernetBroadcast),
+	}
+}
+
+// processReply prints and counts the reply, it returns true if arping is done
+func (a *Arping) processReply(r *Reply) bool {
+	r.RTT = r.receivedAt.Sub(a.lastSent)
+	a.stats.Received++
+	if r.Request {
+		a.stats.RequestsReceived++
+	}
+	if r.Broadcast {
+		a.stats.BroadcastsReceived++
+	}
+	a.stats.Replies = append(a.stats.Replies, *r)
+
+	kind, op := "Unicast", "reply"
+	if r.Broadcast {
+		kind = "Broadcast"
+	}
+	if r.Request {
+		op = "request"
+	}
+	fmt.Printf("%s %s from %s [%s]  %.3fms\n", kind, op, r.IP, strings.ToUpper(r.HardwareAddr.String()),
+		float64(r.RTT)/float64(time.Millisecond))
+
+	// the following probes are sent to target only
+	if !a.Broadcast && !a.DAD {
+		a.dstMAC = r.HardwareAddr
+	}
+	return a.Quit || a.DAD || (a.Count > 0 && a.Deadline > 0 && a.stats.Received >= a.Count)
+}
@@ -0,0 +1,124 @@
+package arping
+
+import (
+	"net"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/google/gopacket/layers"
+)
+
+func TestArping_parseReply(t *testing.T) {
+	ourMAC := net.HardwareAddr{0x02, 0, 0, 0, 0, 0x01}
+	targetMAC := net.HardwareAddr{0x02, 0, 0, 0, 0, 0x02}
+	ourIP, targetIP := net.IPv4(10, 0, 0, 1).To4(), net.IPv4(10, 0, 0, 5).To4()
+
+	tests := []struct {
+		name     string
+		dad      bool
+		op       uint16
+		dstMAC   net.HardwareAddr
+		sender   net.IP
+		srcMAC   net.HardwareAddr
+		target   net.IP
+		expected *Reply
+	}{
+		{
+			name:     "unicast reply",
+			op:       layers.ARPReply,
+			dstMAC:   ourMAC,
+			sender:   targetIP,
+			srcMAC:   targetMAC,
+			target:   ourIP,
+			expected: &Reply{IP: targetIP, HardwareAddr: targetMAC},
+		},
+		{
+			name:     "broadcast reply",
+			op:       layers.ARPReply,
+			dstMAC:   layers.EthernetBroadcast,
+			sender:   targetIP,
+			srcMAC:   targetMAC,
+			target:   ourIP,
+			expected: &Reply{IP: targetIP, HardwareAddr: targetMAC, Broadcast: true},
+		},
+		{
+			name:   "reply of other host",
+			op:     layers.ARPReply,
+			dstMAC: ourMAC,
+			sender: net.IPv4(10, 0, 0, 6).To4(),
+			srcMAC: targetMAC,
+			target: ourIP,
+		},
+		{
+			name:   "our own request",
+			op:     layers.ARPRequest,
+			dstMAC: layers.EthernetBroadcast,
+			sender: ourIP,
+			srcMAC: ourMAC,
+			target: targetIP,
+		},
+		{
+			name:   "reply to other address",
+			op:     layers.ARPReply,
+			dstMAC: ourMAC,
+			sender: targetIP,
+			srcMAC: targetMAC,
+			target: net.IPv4(10, 0, 0, 2).To4(),
+		},
+		{
+			name:     "dad reply",
+			dad:      true,
+			op:       layers.ARPReply,
+			dstMAC:   ourMAC,
+			sender:   targetIP,
+			srcMAC:   targetMAC,
+			target:   net.IPv4zero.To4(),
+			expected: &Reply{IP: targetIP, HardwareAddr: targetMAC},
+		},
+		{
+			name:     "dad announcement of target",
+			dad:      true,
+			op:       layers.ARPRequest,
+			dstMAC:   layers.EthernetBroadcast,
+			sender:   targetIP,
+			srcMAC:   targetMAC,
+			target:   targetIP,
+			expected: &Reply{IP: targetIP, HardwareAddr: targetMAC, Request: true, Broadcast: true},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &Arping{
+				DAD:      tt.dad,
+				intf:     &net.Interface{Name: "eth0", HardwareAddr: ourMAC},
+				targetIP: targetIP,
+				sourceIP: ourIP,
+				logger:   logr.Discard(),
+			}
+			if tt.dad {
+				a.sourceIP = net.IPv4zero.To4()
+			}
+			targetHw := ourMAC
+			if tt.op == layers.ARPRequest {
+				targetHw = zeroMAC
+			}
+			frame, err := arpFrame(tt.op, tt.srcMAC, tt.dstMAC, tt.sender, targetHw, tt.target)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			reply := a.parseReply(frame)
+			if (reply == nil) != (tt.expected == nil) {
+				t.Fatalf("expected reply %v, got %v", tt.expected, reply)
+			}
+			if reply == nil {
+				return
+			}
+			if !reply.IP.Equal(tt.expected.IP) || reply.HardwareAddr.String() != tt.expected.HardwareAddr.String() ||
+				reply.Request != tt.expected.Request || reply.Broadcast != tt.expected.Broadcast {
+				t.Errorf("expected reply %+v, got %+v", tt.expected, reply)
+			}
+		})
+	}
+}
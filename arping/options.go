@@ -0,0 +1,31 @@
+package arping
+
+import "time"
+
+type Options struct {
+	// Interface specifies the network interface to send arp packets, it's the egress
+	// interface of the route to target if empty
+	Interface string
+	// Source specifies the sender ip address, it defaults to the address of interface
+	Source string
+	// Count is the number of arp packets to send, 0 means sending until interrupted
+	Count int
+	// Interval is the time between sending each packet. Defaults to 1 second.
+	Interval time.Duration
+	// Deadline is the time before arping exits regardless of how many packets are sent.
+	// With Count, arping waits for Count replies until the deadline instead.
+	Deadline time.Duration
+	// Quit exits after the first reply
+	Quit bool
+	// Broadcast keeps broadcasting requests, otherwise the requests are sent to the mac
+	// address of target once it replied
+	Broadcast bool
+	// DAD is the duplicate address detection mode, RFC 5227. The sender ip address is
+	// 0.0.0.0, and any reply means the target address is in use. It implies Quit.
+	DAD bool
+	// Unsolicited sends gratuitous arp requests announcing target, which should be an
+	// address of interface, to update the arp caches of neighbors. No reply is expected.
+	Unsolicited bool
+	// Advert is like Unsolicited, but arp replies are sent instead of requests
+	Advert bool
+}
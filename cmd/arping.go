@@ -0,0 +1,85 @@
+/*
+Copyright © 2023 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/joyme123/gnt/arping"
+)
+
+// arpingCmd represents the arping command
+var arpingCmd = &cobra.Command{
+	Use:   "arping",
+	Short: "Send arp requests to a neighbor host",
+	Long: `Send arp requests on an interface and report the mac addresses and round trip
+times of replies, e.g.
+
+  gnt arping -I eth0 10.0.0.5
+
+It can also detect whether an address is in use (-D), or announce an address of
+the interface to update the arp caches of neighbors (-U/-A).`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) == 0 {
+			log.Println("must specify a target address to arping")
+			os.Exit(1)
+		}
+
+		ctx, _ := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+		arper := arping.NewArping(&arpingOpts, args[0], DebugLogger)
+		stats, err := arper.Run(ctx)
+		if stats != nil {
+			printArpingStatistics(stats)
+		}
+		if err != nil {
+			log.Printf("arping failed: %v\n", err)
+			os.Exit(1)
+		}
+
+		// like iputils, it fails if the address is in use in DAD mode, or target doesn't reply
+		switch {
+		case arpingOpts.Unsolicited || arpingOpts.Advert:
+		case arpingOpts.DAD:
+			if stats.Received > 0 {
+				os.Exit(1)
+			}
+		case stats.Received == 0:
+			os.Exit(1)
+		}
+	},
+}
+
+var arpingOpts arping.Options
+
+func printArpingStatistics(s *arping.Statistics) {
+	log.Printf("Sent %d probes (%d broadcast(s))\n", s.Sent, s.BroadcastSent)
+	received := fmt.Sprintf("Received %d response(s)", s.Received)
+	if s.RequestsReceived > 0 || s.BroadcastsReceived > 0 {
+		received += fmt.Sprintf(" (%d request(s), %d broadcast(s))", s.RequestsReceived, s.BroadcastsReceived)
+	}
+	log.Println(received)
+}
+
+func init() {
+	rootCmd.AddCommand(arpingCmd)
+
+	arpingCmd.Flags().StringVarP(&arpingOpts.Interface, "interface", "I", "", "Interface to send arp packets, it's chosen by the route to target if not specified")
+	arpingCmd.Flags().StringVarP(&arpingOpts.Source, "source", "s", "", "Sender ip address of arp packets")
+	arpingCmd.Flags().IntVarP(&arpingOpts.Count, "count", "c", 0, "Stop after sending count packets. With deadline, wait for count replies instead")
+	arpingCmd.Flags().VarP(newSecondsValue(time.Second, &arpingOpts.Interval), "interval", "i", "Wait interval seconds between sending each packet")
+	arpingCmd.Flags().VarP(newSecondsValue(0, &arpingOpts.Deadline), "deadline", "w", "Exit after deadline seconds regardless of how many packets are sent")
+	arpingCmd.Flags().BoolVarP(&arpingOpts.Quit, "quit", "f", false, "Exit after the first reply")
+	arpingCmd.Flags().BoolVarP(&arpingOpts.Broadcast, "broadcast", "b", false, "Keep broadcasting requests instead of sending them to the mac address of target")
+	arpingCmd.Flags().BoolVarP(&arpingOpts.DAD, "dad", "D", false, "Duplicate address detection mode, any reply means the address is in use")
+	arpingCmd.Flags().BoolVarP(&arpingOpts.Unsolicited, "unsolicited", "U", false, "Send gratuitous arp requests to update the arp caches of neighbors")
+	arpingCmd.Flags().BoolVarP(&arpingOpts.Advert, "advert", "A", false, "Like -U, but send gratuitous arp replies")
+}
@@ -0,0 +1,40 @@
+package cmd
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// secondsValue is a time.Duration flag which accepts seconds in float point,
+// e.g. 0.2 means 200 milliseconds. Duration string like 200ms is accepted too.
+type secondsValue time.Duration
+
+func newSecondsValue(val time.Duration, p *time.Duration) *secondsValue {
+	*p = val
+	return (*secondsValue)(p)
+}
+
+func (d *secondsValue) Set(s string) error {
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		duration, derr := time.ParseDuration(s)
+		if derr != nil {
+			return fmt.Errorf("invalid seconds %q", s)
+		}
+		v = duration.Seconds()
+	}
+	if v < 0 {
+		return fmt.Errorf("seconds %q must not be negative", s)
+	}
+	*d = secondsValue(v * float64(time.Second))
+	return nil
+}
+
+func (d *secondsValue) Type() string {
+	return "seconds"
+}
+
+func (d *secondsValue) String() string {
+	return strconv.FormatFloat(time.Duration(*d).Seconds(), 'f', -1, 64)
+}
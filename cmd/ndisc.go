@@ -0,0 +1,77 @@
+/*
+Copyright © 2023 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/joyme123/gnt/ndisc"
+)
+
+// ndiscCmd represents the ndisc command
+var ndiscCmd = &cobra.Command{
+	Use:   "ndisc",
+	Short: "Send ipv6 neighbor solicitations to a neighbor host",
+	Long: `Send icmpv6 neighbor solicitations on an interface and report the link layer
+addresses, flags and round trip times of neighbor advertisements, e.g.
+
+  gnt ndisc -I eth0 fe80::1
+
+It's the ipv6 counterpart of arping, and can also detect whether an address is
+in use (-D).`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) == 0 {
+			log.Println("must specify a target address to ndisc")
+			os.Exit(1)
+		}
+
+		ctx, _ := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+		solicitor := ndisc.NewNdisc(&ndiscOpts, args[0], DebugLogger)
+		stats, err := solicitor.Run(ctx)
+		if stats != nil {
+			printNdiscStatistics(stats)
+		}
+		if err != nil {
+			log.Printf("ndisc failed: %v\n", err)
+			os.Exit(1)
+		}
+
+		// it fails if the address is in use in DAD mode, or target doesn't advertise
+		if (ndiscOpts.DAD && stats.Received > 0) || (!ndiscOpts.DAD && stats.Received == 0) {
+			os.Exit(1)
+		}
+	},
+}
+
+var ndiscOpts ndisc.Options
+
+func printNdiscStatistics(s *ndisc.Statistics) {
+	log.Printf("Sent %d probes (%d multicast(s))\n", s.Sent, s.MulticastSent)
+	received := fmt.Sprintf("Received %d response(s)", s.Received)
+	if s.SolicitationsReceived > 0 || s.MulticastsReceived > 0 {
+		received += fmt.Sprintf(" (%d solicitation(s), %d multicast(s))", s.SolicitationsReceived, s.MulticastsReceived)
+	}
+	log.Println(received)
+}
+
+func init() {
+	rootCmd.AddCommand(ndiscCmd)
+
+	ndiscCmd.Flags().StringVarP(&ndiscOpts.Interface, "interface", "I", "", "Interface to send solicitations, it's chosen by the route to target if not specified")
+	ndiscCmd.Flags().StringVarP(&ndiscOpts.Source, "source", "s", "", "Source ip address of solicitations")
+	ndiscCmd.Flags().IntVarP(&ndiscOpts.Count, "count", "c", 0, "Stop after sending count solicitations. With deadline, wait for count advertisements instead")
+	ndiscCmd.Flags().VarP(newSecondsValue(time.Second, &ndiscOpts.Interval), "interval", "i", "Wait interval seconds between sending each solicitation")
+	ndiscCmd.Flags().VarP(newSecondsValue(0, &ndiscOpts.Deadline), "deadline", "w", "Exit after deadline seconds regardless of how many solicitations are sent")
+	ndiscCmd.Flags().BoolVarP(&ndiscOpts.Quit, "quit", "f", false, "Exit after the first advertisement")
+	ndiscCmd.Flags().BoolVarP(&ndiscOpts.Multicast, "multicast", "m", false, "Keep sending solicitations to the solicited-node multicast address instead of target")
+	ndiscCmd.Flags().BoolVarP(&ndiscOpts.DAD, "dad", "D", false, "Duplicate address detection mode, any advertisement means the address is in use")
+}
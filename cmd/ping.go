@@ -4,11 +4,16 @@ Copyright © 2023 NAME HERE <EMAIL ADDRESS>
 package cmd
 
 import (
+	"bufio"
 	"context"
+	"fmt"
+	"io"
 	"log"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
+	"time"
 
 	"github.com/spf13/cobra"
 
@@ -17,6 +22,22 @@ import (
 
 var pinger = ping.Pinger{}
 
+var (
+	// targetsFile is the file to read targets from, "-" means stdin
+	targetsFile string
+	// showAlive shows targets that are alive
+	showAlive bool
+	// showUnreachable shows targets that are unreachable
+	showUnreachable bool
+	// histogramBuckets is the number of buckets of latency histogram, 0 means no histogram
+	histogramBuckets int
+	// sweeper discovers alive hosts in the address range of its CIDR
+	sweeper = ping.Sweeper{Pinger: &pinger}
+)
+
+// histogramWidth is the width of the longest bar in latency histogram
+const histogramWidth = 50
+
 // pingCmd represents the ping command
 var pingCmd = &cobra.Command{
 	Use:   "ping",
@@ -28,23 +49,257 @@ Cobra is a CLI library for Go that empowers applications.
 This application is a tool to generate the needed files
 to quickly create a Cobra application.`,
 	Run: func(cmd *cobra.Command, args []string) {
+		ctx, _ := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+
+		if (pinger.TCP || pinger.UDP) && cmd.Flags().Changed("pattern") {
+			// -p is taken as the port by other tcp and udp ping tools, the data of udp probes
+			// is set by --udp-data
+			log.Println("-p is the payload pattern, which can't be used with tcp or udp probes, the port is set by --port")
+			os.Exit(1)
+		}
+		if sweeper.CIDR != "" {
+			runSweep(ctx)
+			return
+		}
+		if targetsFile != "" || len(args) > 1 {
+			targets, err := readTargets(targetsFile, args)
+			if err != nil {
+				log.Printf("read targets failed: %v\n", err)
+				os.Exit(1)
+			}
+			runMultiPing(ctx, targets)
+			return
+		}
+
 		if len(args) == 0 {
 			log.Println("must specify a target address to ping")
 			os.Exit(1)
 		}
 		pinger.TargetAddr = args[0]
+		if pinger.PMTU && !cmd.Flags().Changed("wait-time") {
+			// the probes dropped silently are waited for a shorter time by default
+			pinger.WaitTime = 0
+		}
 
 		pinger.SetLogger(log.Default())
 		pinger.SetDebugLogger(DebugLogger)
 
-		ctx, _ := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
-		if err := pinger.Run(ctx); err != nil {
+		stats, err := pinger.Run(ctx)
+		if stats != nil {
+			printPingStatistics(stats)
+		}
+		if err != nil {
 			log.Printf("ping failed: %v\n", err)
 			os.Exit(1)
 		}
 	},
 }
 
+func runMultiPing(ctx context.Context, targets []string) {
+	multiPinger := ping.MultiPinger{
+		Pinger:  &pinger,
+		Targets: targets,
+	}
+	multiPinger.SetLogger(log.Default())
+	multiPinger.SetDebugLogger(DebugLogger)
+
+	stats, err := multiPinger.Run(ctx)
+
+	log.Println()
+	var alive, unreachable []string
+	for _, s := range stats {
+		line := fmt.Sprintf("%-20s : xmt/rcv/%%loss = %d/%d/%.0f%%", s.Target, s.PacketsSent, s.PacketsRecv, s.PacketLoss())
+		if s.PacketsRecv > 0 {
+			line += fmt.Sprintf(", min/avg/max = %s/%s/%s", ms(s.MinRtt), ms(s.AvgRtt), ms(s.MaxRtt))
+			alive = append(alive, s.Target)
+		} else {
+			unreachable = append(unreachable, s.Target)
+		}
+		log.Println(line)
+	}
+
+	if showAlive {
+		log.Printf("\n--- alive ---\n")
+		for _, t := range alive {
+			log.Println(t)
+		}
+	}
+	if showUnreachable {
+		log.Printf("\n--- unreachable ---\n")
+		for _, t := range unreachable {
+			log.Println(t)
+		}
+	}
+
+	if err != nil {
+		log.Printf("ping failed: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func runSweep(ctx context.Context) {
+	pinger.SetLogger(log.Default())
+	pinger.SetDebugLogger(DebugLogger)
+
+	results, err := sweeper.Run(ctx)
+
+	alive := 0
+	for _, r := range results {
+		if r.Alive {
+			alive++
+		}
+	}
+	log.Printf("\n--- %s sweep: %d of %d hosts alive ---\n", sweeper.CIDR, alive, len(results))
+	for _, r := range results {
+		if !r.Alive {
+			continue
+		}
+		line := fmt.Sprintf("%-20s ", r.Target)
+		if r.PacketsRecv > 0 {
+			line += fmt.Sprintf("min/avg/max = %s/%s/%s ms", ms(r.MinRtt), ms(r.AvgRtt), ms(r.MaxRtt))
+		} else {
+			line += fmt.Sprintf("no ping reply, link layer address %s", r.HardwareAddr)
+		}
+		if len(r.Names) > 0 {
+			line += "  " + strings.Join(r.Names, ", ")
+		}
+		log.Println(line)
+	}
+
+	if err != nil {
+		log.Printf("sweep failed: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// readTargets reads targets from args and file, one target per line, lines start
+// with # are ignored.
+func readTargets(file string, args []string) ([]string, error) {
+	targets := append([]string{}, args...)
+	if file == "" {
+		return targets, nil
+	}
+
+	var r io.Reader = os.Stdin
+	if file != "-" {
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		targets = append(targets, strings.Fields(line)[0])
+	}
+	return targets, scanner.Err()
+}
+
+func printPingStatistics(s *ping.Statistics) {
+	log.Printf("\n--- %s ping statistics ---\n", s.Addr)
+	summary := fmt.Sprintf("%d packets transmitted, %d packets received", s.PacketsSent, s.PacketsRecv)
+	if s.Duplicates > 0 {
+		summary += fmt.Sprintf(", +%d duplicates", s.Duplicates)
+	}
+	if s.Late > 0 {
+		summary += fmt.Sprintf(", +%d late", s.Late)
+	}
+	if s.Reordered > 0 {
+		summary += fmt.Sprintf(", %d reordered", s.Reordered)
+	}
+	if s.Corrupted > 0 {
+		summary += fmt.Sprintf(", %d corrupted", s.Corrupted)
+	}
+	if s.Errors > 0 {
+		summary += fmt.Sprintf(", +%d errors", s.Errors)
+	}
+	log.Printf("%s, %.1f%% packet loss\n", summary, s.PacketLoss())
+	if s.PacketsRecv > 0 {
+		log.Printf("round-trip min/avg/max/mdev = %s/%s/%s/%s ms\n",
+			ms(s.MinRtt), ms(s.AvgRtt), ms(s.MaxRtt), ms(s.MdevRtt))
+		log.Printf("percentiles p50/p90/p95/p99 = %s/%s/%s/%s ms, jitter %s ms\n",
+			ms(s.P50Rtt), ms(s.P90Rtt), ms(s.P95Rtt), ms(s.P99Rtt), ms(s.Jitter))
+	}
+	if pinger.Flood || pinger.Adaptive {
+		log.Printf("ipg/ewma %s/%s ms, rate %.1f packets/s\n", ms(s.Ipg), ms(s.EwmaRtt), s.Rate())
+	}
+	if histogramBuckets > 0 {
+		printHistogram(s.Histogram(histogramBuckets))
+	}
+	if len(s.Responders) > 0 {
+		printResponders(s)
+	}
+	if len(s.ClockOffsets) > 0 {
+		log.Printf("clock offset min/median/max = %s/%s/%s ms\n",
+			ms(s.MinClockOffset), ms(s.MedianClockOffset), ms(s.MaxClockOffset))
+	}
+	if s.PathMTU > 0 {
+		log.Printf("path mtu %d\n", s.PathMTU)
+		for _, hop := range s.MTUHops {
+			log.Printf("  mtu %d advertised by %s\n", hop.MTU, hop.Addr)
+		}
+	}
+}
+
+// printResponders prints the number of hosts answered each round and the statistics of
+// each host
+func printResponders(s *ping.Statistics) {
+	min, max, sum := 0, 0, 0
+	for i, n := range s.RoundResponders {
+		if i == 0 || n < min {
+			min = n
+		}
+		if n > max {
+			max = n
+		}
+		sum += n
+	}
+	avg := 0.0
+	if len(s.RoundResponders) > 0 {
+		avg = float64(sum) / float64(len(s.RoundResponders))
+	}
+	log.Printf("%d hosts answered, hosts per round min/avg/max = %d/%.1f/%d\n", len(s.Responders), min, avg, max)
+	for _, r := range s.Responders {
+		line := fmt.Sprintf("  %-20s %d received", r.Addr, r.PacketsRecv)
+		if r.Duplicates > 0 {
+			line += fmt.Sprintf(", +%d duplicates", r.Duplicates)
+		}
+		log.Printf("%s, rtt min/avg/max/mdev = %s/%s/%s/%s ms\n", line, ms(r.MinRtt), ms(r.AvgRtt), ms(r.MaxRtt), ms(r.MdevRtt))
+	}
+}
+
+// printHistogram prints latency histogram as ascii bars, the longest bar is histogramWidth
+func printHistogram(hist []ping.HistogramBucket) {
+	max := 0
+	for _, b := range hist {
+		if b.Count > max {
+			max = b.Count
+		}
+	}
+	if max == 0 {
+		return
+	}
+
+	log.Printf("\nlatency histogram (ms):\n")
+	for _, b := range hist {
+		bar := strings.Repeat("#", b.Count*histogramWidth/max)
+		if bar == "" && b.Count > 0 {
+			bar = "#"
+		}
+		log.Printf("%10s - %-10s %6d |%s\n", ms(b.Low), ms(b.High), b.Count, bar)
+	}
+}
+
+func ms(d time.Duration) string {
+	return fmt.Sprintf("%.3f", float64(d)/float64(time.Millisecond))
+}
+
 func init() {
 	rootCmd.AddCommand(pingCmd)
 
@@ -57,11 +312,41 @@ func init() {
 	// Cobra supports local flags which will only run when this command
 	// is called directly, e.g.:
 	pingCmd.Flags().IntVarP(&pinger.Count, "count", "c", 0, "times of sending icmp echo request")
-	pingCmd.Flags().IntVarP(&pinger.Interval, "interval", "i", 1, "interval")
-	pingCmd.Flags().StringVarP(&pinger.Interface, "interface", "I", "", "interface")
+	pingCmd.Flags().VarP(newSecondsValue(0, &pinger.Interval), "interval", "i", "seconds between sending each packet (default 1, 0.01 in flood mode)")
+	pingCmd.Flags().StringVarP(&pinger.Interface, "interface", "I", "", "interface name or source address")
 	pingCmd.Flags().IntVarP(&pinger.TTL, "ttl", "t", 64, "ttl")
-	pingCmd.Flags().IntVarP(&pinger.Timeout, "timeout", "W", 0, "timeout")
-	pingCmd.Flags().IntVarP(&pinger.Deadline, "deadline", "w", 1, "deadline")
+	pingCmd.Flags().VarP(newSecondsValue(0, &pinger.Timeout), "timeout", "W", "seconds to run before ping exits")
+	pingCmd.Flags().VarP(newSecondsValue(time.Second, &pinger.Deadline), "deadline", "w", "seconds to wait for each read or write")
+	pingCmd.Flags().IntVarP(&pinger.Preload, "preload", "l", 0, "number of packets sent back to back before the normal pacing starts")
+	pingCmd.Flags().BoolVarP(&pinger.Flood, "flood", "f", false, "send the next packet as soon as a reply comes back")
+	pingCmd.Flags().BoolVar(&pinger.TCP, "tcp", false, "send tcp syn to port and measure the time until syn-ack or rst comes back")
+	pingCmd.Flags().BoolVar(&pinger.UDP, "udp", false, "send udp datagrams to port and measure the time until the reply or port unreachable comes back")
+	pingCmd.Flags().BytesHexVar(&pinger.UDPData, "udp-data", nil, "hex bytes sent in udp probes, defaults to the same data as icmp")
+	pingCmd.Flags().BytesHexVar(&pinger.UDPExpect, "udp-expect", nil, "hex bytes expected in udp replies, defaults to the data sent")
+	pingCmd.Flags().IntVar(&pinger.Port, "port", 0, "destination port of tcp/udp probes (default 80 for tcp, 7 for udp), it has no short flag because -p is the payload pattern")
+	pingCmd.Flags().BoolVarP(&pinger.Adaptive, "adaptive", "A", false, "adapt the interval to round trip time")
+	pingCmd.Flags().IntVarP(&pinger.Size, "size", "s", 56, "number of data bytes to be sent")
+	pingCmd.Flags().BytesHexVarP(&pinger.Pattern, "pattern", "p", nil, "hex bytes to fill the packet data, e.g. ff00")
+	pingCmd.Flags().BoolVar(&pinger.RandomPayload, "random-payload", false, "fill the packet data with random bytes")
+	pingCmd.Flags().Var(newSecondsValue(10*time.Second, &pinger.WaitTime), "wait-time", "seconds to wait for a reply, later replies are counted as lost")
 	pingCmd.Flags().BoolVarP(&pinger.Unprivileged, "unprivileged", "u", false, "send unprivileged icmp")
+	pingCmd.Flags().BoolVar(&pinger.PMTU, "pmtu", false, "discover the path mtu with DF set instead of pinging")
+	pingCmd.Flags().BoolVarP(&pinger.RecordRoute, "record-route", "R", false, "record route in ipv4 option, the route is printed under each reply")
+	pingCmd.Flags().StringVarP(&pinger.TimestampOption, "timestamp-option", "T", "", "record timestamps in ipv4 option: tsonly or tsandaddr")
+	pingCmd.Flags().BoolVarP(&pinger.Broadcast, "broadcast", "b", false, "allow pinging a broadcast address, all hosts answered broadcast or multicast probes are listed")
+	pingCmd.Flags().BoolVar(&pinger.ICMPTimestamp, "timestamp", false, "send icmp timestamp request and estimate the clock offset of target")
+	pingCmd.Flags().IntVarP(&pinger.TOS, "tos", "Q", 0, "type of service(ipv4) or traffic class(ipv6) of packets, including dscp and ecn bits")
+	pingCmd.Flags().IntVarP(&pinger.Mark, "mark", "m", 0, "firewall mark of packets")
+	pingCmd.Flags().StringVar(&pinger.BindDevice, "bind-device", "", "bind sockets to the interface or vrf")
+	pingCmd.Flags().StringVarP(&pinger.PMTUDisc, "pmtudisc", "M", "", "path mtu discovery strategy: do(prohibit fragmentation), dont(allow fragmentation), want or probe")
+	pingCmd.Flags().StringVar(&sweeper.CIDR, "sweep", "", "ping all hosts in the cidr, e.g. 10.0.0.0/22, and list the alive hosts")
+	pingCmd.Flags().Float64Var(&sweeper.Rate, "rate", 0, "max number of probes sent per second in sweep, 0 means no limit")
+	pingCmd.Flags().IntVar(&sweeper.Concurrency, "concurrency", 64, "max number of hosts pinged at the same time in sweep")
+	pingCmd.Flags().BoolVar(&sweeper.ResolveNames, "rdns", false, "look up the names of alive hosts by reverse dns in sweep")
+	pingCmd.Flags().BoolVar(&sweeper.NeighborCache, "neighbor-cache", false, "take on-link hosts resolved in the kernel neighbor cache as alive in sweep, even if they drop icmp; no arp/ndp probe is sent")
+	pingCmd.Flags().StringVar(&targetsFile, "file", "", "read list of targets from a file, - means stdin")
+	pingCmd.Flags().BoolVar(&showAlive, "alive", false, "show targets that are alive when pinging multiple targets")
+	pingCmd.Flags().IntVar(&histogramBuckets, "histogram", 0, "print latency histogram with the number of buckets at exit")
+	pingCmd.Flags().BoolVar(&showUnreachable, "unreachable", false, "show targets that are unreachable when pinging multiple targets")
 
 }
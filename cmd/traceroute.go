@@ -9,6 +9,7 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/spf13/cobra"
 
@@ -72,6 +73,15 @@ initial seq for "icmp" (incremented as well,
 default from 1), or some constant destination
 port for other methods (with default of 80 for
 "tcp", 53 for "udp", etc.)`)
-	tracerouteCmd.Flags().IntVarP(&opt.SendWait, "sendwait", "z", 0, "Minimal time interval between probes (default 0). If the value is more than 10, then it specifies a number in milliseconds, else it is a number of seconds (float point values allowed too)")
-	tracerouteCmd.Flags().BoolVarP(&opt.Unprivileged, "unprivileged", "u", true, "unprivileged mode")
+	tracerouteCmd.Flags().VarP(newSecondsValue(5*time.Second, &opt.WaitTime), "wait", "w", "Set the time (in seconds) to wait for a response to a probe")
+	tracerouteCmd.Flags().VarP(newSecondsValue(0, &opt.SendWait), "sendwait", "z", "Minimal time interval between probes. If the value is more than 10, then it specifies a number in milliseconds, else it is a number of seconds (float point values allowed too)")
+	tracerouteCmd.Flags().BoolVarP(&opt.Unprivileged, "unprivileged", "u", true, "unprivileged mode, only for icmp method and --mtu. The icmp errors of udp and tcp probes are received by raw socket")
+	tracerouteCmd.Flags().BoolVar(&opt.MTU, "mtu", false, "Discover the mtu along the path being traced, like tracepath")
+	tracerouteCmd.Flags().BoolVar(&opt.Paris, "paris", false, "Keep the five-tuple of probes constant like paris traceroute, so the probes are not spread over paths by per-flow load balancers")
+	tracerouteCmd.Flags().BoolVar(&opt.Multipath, "multipath", false, "Discover all the load balanced paths with a simplified multipath detection algorithm (stopping rule per hop, no node control) and print the hop graph, the interfaces with several next hops are branching points. It implies --paris")
+	tracerouteCmd.Flags().Float64Var(&opt.Confidence, "confidence", 0.95, "Set the confidence level of multipath detection that all the next hops are found")
+	tracerouteCmd.Flags().IntVarP(&opt.TOS, "tos", "Q", 0, "Set the type of service(ipv4) or traffic class(ipv6) of probes, including dscp and ecn bits")
+	tracerouteCmd.Flags().IntVar(&opt.Mark, "mark", 0, "Set the firewall mark of probes")
+	tracerouteCmd.Flags().StringVar(&opt.BindDevice, "bind-device", "", "Bind sockets to the interface or vrf")
+	tracerouteCmd.Flags().StringVarP(&opt.PMTUDisc, "pmtudisc", "M", "", "Set the path mtu discovery strategy: do(prohibit fragmentation), dont(allow fragmentation), want or probe")
 }
@@ -0,0 +1,459 @@
+package ndisc
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcap"
+	"github.com/pkg/errors"
+
+	"github.com/joyme123/gnt/utils"
+)
+
+const (
+	// ndpHopLimit is the hop limit of neighbor discovery messages, the messages with other
+	// hop limits are discarded because they may be forwarded by routers, RFC 4861
+	ndpHopLimit = 255
+
+	// flags of neighbor advertisement
+	flagRouter    = 0x80
+	flagSolicited = 0x40
+	flagOverride  = 0x20
+)
+
+var (
+	// snaplen is number of bytes max to read per packet
+	snaplen = 256
+	// readTimeout is the timeout of reading a packet, so that the reader checks whether ndisc is done
+	readTimeout = 100 * time.Millisecond
+	// defaultInterval is the default time between sending each solicitation
+	defaultInterval = time.Second
+)
+
+// Advertisement is a neighbor advertisement of target in response to the solicitations
+type Advertisement struct {
+	// IP is the source address of advertisement
+	IP net.IP
+	// HardwareAddr is the link layer address of target, it's the target link-layer address
+	// option, or the source mac address if the option is absent
+	HardwareAddr net.HardwareAddr
+	// RTT is the time since the last solicitation was sent
+	RTT time.Duration
+	// Router is true if the sender is a router
+	Router bool
+	// Solicited is true if the advertisement is sent in response to a solicitation
+	Solicited bool
+	// Override is true if the advertisement should override the cached link layer address
+	Override bool
+	// Solicitation is true if the packet is a solicitation of another node detecting the
+	// target address in DAD mode, which means that the address is tentative there
+	Solicitation bool
+	// Multicast is true if the packet is sent to a multicast address
+	Multicast bool
+
+	receivedAt time.Time
+}
+
+// flags returns the names of flags which are set
+func (a *Advertisement) flags() []string {
+	var flags []string
+	if a.Router {
+		flags = append(flags, "router")
+	}
+	if a.Solicited {
+		flags = append(flags, "solicited")
+	}
+	if a.Override {
+		flags = append(flags, "override")
+	}
+	return flags
+}
+
+// Statistics is the statistics of ndisc
+type Statistics struct {
+	// Target is the target ip address
+	Target string
+	// Interface is the interface sending solicitations
+	Interface string
+	// Sent is the number of solicitations sent
+	Sent int
+	// MulticastSent is the number of solicitations sent to the solicited-node multicast address
+	MulticastSent int
+	// Received is the number of advertisements received
+	Received int
+	// SolicitationsReceived is the number of solicitations of other nodes detecting the
+	// target address in DAD mode
+	SolicitationsReceived int
+	// MulticastsReceived is the number of advertisements sent to a multicast address
+	MulticastsReceived int
+	// Advertisements are the advertisements in receiving order
+	Advertisements []Advertisement
+}
+
+type Ndisc struct {
+	Target    string
+	Interface string
+	Source    string
+	Count     int
+	Interval  time.Duration
+	Deadline  time.Duration
+	Quit      bool
+	Multicast bool
+	DAD       bool
+
+	intf     *net.Interface
+	targetIP net.IP
+	sourceIP net.IP
+	// dstMAC and dstIP are the destination of solicitations, they're the solicited-node
+	// multicast address until target advertised
+	dstMAC   net.HardwareAddr
+	dstIP    net.IP
+	lastSent time.Time
+	stats    Statistics
+
+	logger logr.Logger
+}
+
+func NewNdisc(opt *Options, target string, logger logr.Logger) *Ndisc {
+	n := &Ndisc{
+		Target:    target,
+		Interface: opt.Interface,
+		Source:    opt.Source,
+		Count:     opt.Count,
+		Interval:  opt.Interval,
+		Deadline:  opt.Deadline,
+		Quit:      opt.Quit,
+		Multicast: opt.Multicast,
+		DAD:       opt.DAD,
+		logger:    logger,
+	}
+	if n.Interval == 0 {
+		n.Interval = defaultInterval
+	}
+	return n
+}
+
+// Run sends neighbor solicitations until the context is done, Count solicitations are
+// sent or the deadline exceeded, and returns the statistics.
+func (n *Ndisc) Run(ctx context.Context) (*Statistics, error) {
+	if err := n.init(); err != nil {
+		return nil, err
+	}
+	handle, err := n.openLive()
+	if err != nil {
+		return nil, err
+	}
+	defer handle.Close()
+
+	if n.Deadline > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, n.Deadline)
+		defer cancel()
+	}
+	fmt.Printf("NDISC %s from %s %s\n", n.targetIP, n.sourceIP, n.intf.Name)
+
+	// the reader must exit before the handle is closed
+	adverts := make(chan *Advertisement)
+	errc := make(chan error, 1)
+	readCtx, stop := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if err := n.receive(readCtx, handle, adverts); err != nil {
+			errc <- err
+		}
+	}()
+	defer func() {
+		stop()
+		<-done
+	}()
+
+	ticker := time.NewTicker(n.Interval)
+	defer ticker.Stop()
+	if err := n.send(handle); err != nil {
+		return &n.stats, err
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return &n.stats, nil
+		case err := <-errc:
+			return &n.stats, err
+		case advert := <-adverts:
+			if n.processAdvertisement(advert) {
+				return &n.stats, nil
+			}
+		case <-ticker.C:
+			// with deadline, it keeps sending until Count advertisements are received
+			if n.Count > 0 && n.stats.Sent >= n.Count && n.Deadline == 0 {
+				return &n.stats, nil
+			}
+			if err := n.send(handle); err != nil {
+				return &n.stats, err
+			}
+		}
+	}
+}
+
+func (n *Ndisc) init() error {
+	addr, err := net.ResolveIPAddr("ip6", n.Target)
+	if err != nil {
+		return err
+	}
+	if addr.IP.To4() != nil {
+		return fmt.Errorf("%s is not an ipv6 address", n.Target)
+	}
+	n.targetIP = addr.IP
+	n.stats.Target = n.targetIP.String()
+
+	intfName := n.Interface
+	if intfName == "" {
+		intfName = addr.Zone
+	}
+	if intfName == "" && n.targetIP.IsLinkLocalUnicast() {
+		return fmt.Errorf("link local address %s is ambiguous, specify the interface by -I or zone", n.targetIP)
+	}
+	if intfName == "" {
+		route, err := utils.LookupRoute(n.targetIP, nil)
+		if err != nil {
+			return err
+		}
+		if route.Interface == nil {
+			return fmt.Errorf("no interface found to reach %s, specify it by -I", n.targetIP)
+		}
+		n.intf = route.Interface
+	} else if n.intf, err = net.InterfaceByName(intfName); err != nil {
+		return err
+	}
+	if len(n.intf.HardwareAddr) != 6 {
+		return fmt.Errorf("interface %s is not an ethernet device", n.intf.Name)
+	}
+	n.stats.Interface = n.intf.Name
+
+	switch {
+	case n.Source != "":
+		if n.sourceIP = net.ParseIP(n.Source); n.sourceIP == nil || n.sourceIP.To4() != nil {
+			return fmt.Errorf("invalid ipv6 source address %q", n.Source)
+		}
+	case n.DAD:
+		n.sourceIP = net.IPv6unspecified
+	default:
+		if n.sourceIP, err = utils.SourceAddr(n.targetIP, n.intf.Name); err != nil {
+			return err
+		}
+	}
+	n.dstIP = solicitedNodeAddr(n.targetIP)
+	n.dstMAC = multicastMAC(n.dstIP)
+	return nil
+}
+
+// solicitedNodeAddr returns the solicited-node multicast address of ip, which is formed
+// from the low-order 24 bits of ip, RFC 4291
+func solicitedNodeAddr(ip net.IP) net.IP {
+	addr := net.ParseIP("ff02::1:ff00:0")
+	copy(addr[13:], ip.To16()[13:])
+	return addr
+}
+
+// multicastMAC returns the ethernet address of ipv6 multicast address, RFC 2464
+func multicastMAC(ip net.IP) net.HardwareAddr {
+	mac := net.HardwareAddr{0x33, 0x33, 0, 0, 0, 0}
+	copy(mac[2:], ip.To16()[12:])
+	return mac
+}
+
+func (n *Ndisc) openLive() (*pcap.Handle, error) {
+	inactive, err := pcap.NewInactiveHandle(n.intf.Name)
+	if err != nil {
+		return nil, errors.Wrap(err, "create pcap handle failed")
+	}
+	defer inactive.CleanUp()
+
+	if err := inactive.SetSnapLen(snaplen); err != nil {
+		return nil, errors.Wrap(err, "set snaplen failed")
+	}
+	if err := inactive.SetTimeout(readTimeout); err != nil {
+		return nil, errors.Wrap(err, "set timeout failed")
+	}
+	// deliver the advertisements as soon as they arrive instead of buffering them
+	if err := inactive.SetImmediateMode(true); err != nil {
+		return nil, errors.Wrap(err, "set immediate mode failed")
+	}
+	handle, err := inactive.Activate()
+	if err != nil {
+		return nil, errors.Wrap(err, "open live failed")
+	}
+	if err := handle.SetBPFFilter("icmp6"); err != nil {
+		handle.Close()
+		return nil, errors.Wrap(err, "set bpf filter failed")
+	}
+	return handle, nil
+}
+
+// send sends a neighbor solicitation
+func (n *Ndisc) send(handle *pcap.Handle) error {
+	frame, err := solicitationFrame(n.intf.HardwareAddr, n.dstMAC, n.sourceIP, n.dstIP, n.targetIP)
+	if err != nil {
+		return err
+	}
+	// the advertisement may arrive before writing returns
+	n.lastSent = time.Now()
+	if err := handle.WritePacketData(frame); err != nil {
+		return errors.Wrap(err, "send neighbor solicitation failed")
+	}
+
+	n.stats.Sent++
+	if n.dstIP.IsMulticast() {
+		n.stats.MulticastSent++
+	}
+	return nil
+}
+
+// solicitationFrame builds an ethernet frame of neighbor solicitation. The source
+// link-layer address option is included unless the source address is unspecified (::) as
+// in duplicate address detection, RFC 4861 section 4.3.
+func solicitationFrame(srcMAC, dstMAC net.HardwareAddr, src, dst, target net.IP) ([]byte, error) {
+	eth := layers.Ethernet{
+		SrcMAC:       srcMAC,
+		DstMAC:       dstMAC,
+		EthernetType: layers.EthernetTypeIPv6,
+	}
+	ip6 := layers.IPv6{
+		Version:    6,
+		NextHeader: layers.IPProtocolICMPv6,
+		HopLimit:   ndpHopLimit,
+		SrcIP:      src,
+		DstIP:      dst,
+	}
+	icmp6 := layers.ICMPv6{
+		TypeCode: layers.CreateICMPv6TypeCode(layers.ICMPv6TypeNeighborSolicitation, 0),
+	}
+	if err := icmp6.SetNetworkLayerForChecksum(&ip6); err != nil {
+		return nil, err
+	}
+	ns := layers.ICMPv6NeighborSolicitation{TargetAddress: target}
+	if !src.IsUnspecified() {
+		ns.Options = layers.ICMPv6Options{{Type: layers.ICMPv6OptSourceAddress, Data: srcMAC}}
+	}
+
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	if err := gopacket.SerializeLayers(buf, opts, &eth, &ip6, &icmp6, &ns); err != nil {
+		return nil, errors.Wrap(err, "build neighbor solicitation failed")
+	}
+	return buf.Bytes(), nil
+}
+
+// receive reads the advertisements of target until the context is done
+func (n *Ndisc) receive(ctx context.Context, handle *pcap.Handle, adverts chan<- *Advertisement) error {
+	for ctx.Err() == nil {
+		data, ci, err := handle.ReadPacketData()
+		if err == pcap.NextErrorTimeoutExpired {
+			continue
+		}
+		if err != nil {
+			return errors.Wrap(err, "read packet failed")
+		}
+
+		advert := n.parseAdvertisement(data)
+		if advert == nil {
+			continue
+		}
+		advert.receivedAt = ci.Timestamp
+		select {
+		case adverts <- advert:
+		case <-ctx.Done():
+		}
+	}
+	return nil
+}
+
+// parseAdvertisement parses the frame and returns the advertisement of target, it's nil
+// if the frame doesn't answer the solicitations. The packets of our own mac address are
+// ignored. An advertisement must be sent to the source address or a multicast address,
+// and in DAD mode, the solicitation of another node detecting the same address is
+// returned too.
+func (n *Ndisc) parseAdvertisement(data []byte) *Advertisement {
+	packet := gopacket.NewPacket(data, layers.LayerTypeEthernet, gopacket.NoCopy)
+	ethLayer, ip6Layer := packet.Layer(layers.LayerTypeEthernet), packet.Layer(layers.LayerTypeIPv6)
+	if ethLayer == nil || ip6Layer == nil {
+		return nil
+	}
+	eth, ip6 := ethLayer.(*layers.Ethernet), ip6Layer.(*layers.IPv6)
+	if ip6.HopLimit != ndpHopLimit || bytes.Equal(eth.SrcMAC, n.intf.HardwareAddr) {
+		return nil
+	}
+
+	advert := &Advertisement{
+		IP:           append(net.IP(nil), ip6.SrcIP...),
+		HardwareAddr: append(net.HardwareAddr(nil), eth.SrcMAC...),
+		Multicast:    ip6.DstIP.IsMulticast(),
+	}
+	if na, ok := packet.Layer(layers.LayerTypeICMPv6NeighborAdvertisement).(*layers.ICMPv6NeighborAdvertisement); ok {
+		if !na.TargetAddress.Equal(n.targetIP) {
+			return nil
+		}
+		if !n.DAD && !ip6.DstIP.Equal(n.sourceIP) && !advert.Multicast {
+			n.logger.V(4).Info("advertisement not sent to us", "src", ip6.SrcIP.String(), "dst", ip6.DstIP.String())
+			return nil
+		}
+		advert.Router = na.Flags&flagRouter != 0
+		advert.Solicited = na.Flags&flagSolicited != 0
+		advert.Override = na.Flags&flagOverride != 0
+		for _, opt := range na.Options {
+			if opt.Type == layers.ICMPv6OptTargetAddress && len(opt.Data) >= 6 {
+				advert.HardwareAddr = append(net.HardwareAddr(nil), opt.Data[:6]...)
+			}
+		}
+		return advert
+	}
+
+	// another node is detecting the same address
+	if ns, ok := packet.Layer(layers.LayerTypeICMPv6NeighborSolicitation).(*layers.ICMPv6NeighborSolicitation); ok &&
+		n.DAD && ip6.SrcIP.IsUnspecified() && ns.TargetAddress.Equal(n.targetIP) {
+		advert.Solicitation = true
+		return advert
+	}
+	return nil
+}
+
+// processAdvertisement prints and counts the advertisement, it returns true if ndisc is done
+func (n *Ndisc) processAdvertisement(a *Advertisement) bool {
+	a.RTT = a.receivedAt.Sub(n.lastSent)
+	n.stats.Received++
+	if a.Solicitation {
+		n.stats.SolicitationsReceived++
+	}
+	if a.Multicast {
+		n.stats.MulticastsReceived++
+	}
+	n.stats.Advertisements = append(n.stats.Advertisements, *a)
+
+	kind, typ := "Unicast", "advertisement"
+	if a.Multicast {
+		kind = "Multicast"
+	}
+	if a.Solicitation {
+		typ = "solicitation"
+	}
+	var flags string
+	if f := a.flags(); len(f) > 0 {
+		flags = " (" + strings.Join(f, ", ") + ")"
+	}
+	fmt.Printf("%s %s from %s [%s]%s  %.3fms\n", kind, typ, a.IP, strings.ToUpper(a.HardwareAddr.String()), flags,
+		float64(a.RTT)/float64(time.Millisecond))
+
+	// the following solicitations are sent to target only, like neighbor unreachability
+	// detection
+	if !n.Multicast && !n.DAD && !a.Solicitation {
+		n.dstIP, n.dstMAC = n.targetIP, a.HardwareAddr
+	}
+	return n.Quit || n.DAD || (n.Count > 0 && n.Deadline > 0 && n.stats.Received >= n.Count)
+}
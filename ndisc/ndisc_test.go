@@ -0,0 +1,171 @@
+package ndisc
+
+import (
+	"net"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+func Test_solicitedNodeAddr(t *testing.T) {
+	tests := []struct {
+		ip       string
+		expected string
+		mac      string
+	}{
+		{ip: "fe80::6094:3bff:fe99:c3bb", expected: "ff02::1:ff99:c3bb", mac: "33:33:ff:99:c3:bb"},
+		{ip: "2001:db8::1", expected: "ff02::1:ff00:1", mac: "33:33:ff:00:00:01"},
+	}
+	for _, tt := range tests {
+		addr := solicitedNodeAddr(net.ParseIP(tt.ip))
+		if addr.String() != tt.expected {
+			t.Errorf("expected solicited-node address of %s is %s, got %s", tt.ip, tt.expected, addr)
+		}
+		if mac := multicastMAC(addr); mac.String() != tt.mac {
+			t.Errorf("expected mac address of %s is %s, got %s", addr, tt.mac, mac)
+		}
+	}
+}
+
+func TestNdisc_parseAdvertisement(t *testing.T) {
+	ourMAC := net.HardwareAddr{0x02, 0, 0, 0, 0, 0x01}
+	targetMAC := net.HardwareAddr{0x02, 0, 0, 0, 0, 0x02}
+	ourIP, targetIP := net.ParseIP("2001:db8::1"), net.ParseIP("2001:db8::5")
+	allNodes := net.ParseIP("ff02::1")
+
+	tests := []struct {
+		name     string
+		dad      bool
+		ns       bool
+		hopLimit uint8
+		src      net.IP
+		dst      net.IP
+		srcMAC   net.HardwareAddr
+		target   net.IP
+		flags    uint8
+		expected *Advertisement
+	}{
+		{
+			name:     "solicited advertisement",
+			src:      targetIP,
+			dst:      ourIP,
+			srcMAC:   targetMAC,
+			target:   targetIP,
+			flags:    flagRouter | flagSolicited | flagOverride,
+			expected: &Advertisement{IP: targetIP, HardwareAddr: targetMAC, Router: true, Solicited: true, Override: true},
+		},
+		{
+			name:     "unsolicited advertisement",
+			src:      targetIP,
+			dst:      allNodes,
+			srcMAC:   targetMAC,
+			target:   targetIP,
+			flags:    flagOverride,
+			expected: &Advertisement{IP: targetIP, HardwareAddr: targetMAC, Override: true, Multicast: true},
+		},
+		{
+			name:     "forwarded advertisement",
+			hopLimit: 254,
+			src:      targetIP,
+			dst:      ourIP,
+			srcMAC:   targetMAC,
+			target:   targetIP,
+		},
+		{
+			name:   "advertisement of other address",
+			src:    targetIP,
+			dst:    ourIP,
+			srcMAC: targetMAC,
+			target: net.ParseIP("2001:db8::6"),
+		},
+		{
+			name:   "our own solicitation",
+			ns:     true,
+			src:    ourIP,
+			dst:    solicitedNodeAddr(targetIP),
+			srcMAC: ourMAC,
+			target: targetIP,
+		},
+		{
+			name:     "dad of another node",
+			dad:      true,
+			ns:       true,
+			src:      net.IPv6unspecified,
+			dst:      solicitedNodeAddr(targetIP),
+			srcMAC:   targetMAC,
+			target:   targetIP,
+			expected: &Advertisement{IP: net.IPv6unspecified, HardwareAddr: targetMAC, Solicitation: true, Multicast: true},
+		},
+		{
+			name:   "solicitation without dad",
+			ns:     true,
+			src:    net.IPv6unspecified,
+			dst:    solicitedNodeAddr(targetIP),
+			srcMAC: targetMAC,
+			target: targetIP,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n := &Ndisc{
+				DAD:      tt.dad,
+				intf:     &net.Interface{Name: "eth0", HardwareAddr: ourMAC},
+				targetIP: targetIP,
+				sourceIP: ourIP,
+				logger:   logr.Discard(),
+			}
+			var frame []byte
+			var err error
+			if tt.ns {
+				frame, err = solicitationFrame(tt.srcMAC, multicastMAC(tt.dst), tt.src, tt.dst, tt.target)
+			} else {
+				frame, err = advertisementFrame(tt.srcMAC, tt.src, tt.dst, tt.target, tt.flags, tt.hopLimit)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			advert := n.parseAdvertisement(frame)
+			if (advert == nil) != (tt.expected == nil) {
+				t.Fatalf("expected advertisement %v, got %v", tt.expected, advert)
+			}
+			if advert == nil {
+				return
+			}
+			if !advert.IP.Equal(tt.expected.IP) || advert.HardwareAddr.String() != tt.expected.HardwareAddr.String() ||
+				advert.Router != tt.expected.Router || advert.Solicited != tt.expected.Solicited ||
+				advert.Override != tt.expected.Override || advert.Solicitation != tt.expected.Solicitation ||
+				advert.Multicast != tt.expected.Multicast {
+				t.Errorf("expected advertisement %+v, got %+v", tt.expected, advert)
+			}
+		})
+	}
+}
+
+// advertisementFrame builds a neighbor advertisement with target link-layer address option
+func advertisementFrame(srcMAC net.HardwareAddr, src, dst, target net.IP, flags, hopLimit uint8) ([]byte, error) {
+	if hopLimit == 0 {
+		hopLimit = ndpHopLimit
+	}
+	eth := layers.Ethernet{SrcMAC: srcMAC, DstMAC: multicastMAC(dst), EthernetType: layers.EthernetTypeIPv6}
+	ip6 := layers.IPv6{Version: 6, NextHeader: layers.IPProtocolICMPv6, HopLimit: hopLimit, SrcIP: src, DstIP: dst}
+	icmp6 := layers.ICMPv6{TypeCode: layers.CreateICMPv6TypeCode(layers.ICMPv6TypeNeighborAdvertisement, 0)}
+	if err := icmp6.SetNetworkLayerForChecksum(&ip6); err != nil {
+		return nil, err
+	}
+	na := layers.ICMPv6NeighborAdvertisement{
+		Flags:         flags,
+		TargetAddress: target,
+		Options:       layers.ICMPv6Options{{Type: layers.ICMPv6OptTargetAddress, Data: srcMAC}},
+	}
+
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	if err := gopacket.SerializeLayers(buf, opts, &eth, &ip6, &icmp6, &na); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
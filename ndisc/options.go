@@ -0,0 +1,28 @@
+package ndisc
+
+import "time"
+
+type Options struct {
+	// Interface specifies the network interface to send solicitations, it's the egress
+	// interface of the route to target if empty. It's required for link local targets
+	// without zone.
+	Interface string
+	// Source specifies the source ip address, it defaults to the address of interface
+	Source string
+	// Count is the number of solicitations to send, 0 means sending until interrupted
+	Count int
+	// Interval is the time between sending each solicitation. Defaults to 1 second.
+	Interval time.Duration
+	// Deadline is the time before ndisc exits regardless of how many solicitations are
+	// sent. With Count, ndisc waits for Count advertisements until the deadline instead.
+	Deadline time.Duration
+	// Quit exits after the first advertisement
+	Quit bool
+	// Multicast keeps sending solicitations to the solicited-node multicast address of
+	// target, otherwise they are sent to target directly once it advertised
+	Multicast bool
+	// DAD is the duplicate address detection mode, RFC 4862. The source address is the
+	// unspecified address, and any advertisement means the target address is in use. It
+	// implies Quit.
+	DAD bool
+}
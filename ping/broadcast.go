@@ -0,0 +1,186 @@
+package ping
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"time"
+
+	"golang.org/x/net/icmp"
+
+	"github.com/joyme123/gnt/utils"
+)
+
+// responder is a host answered the probes sent to broadcast or multicast address
+type responder struct {
+	addr       string
+	rtts       []time.Duration
+	duplicates int
+}
+
+// ResponderStatistics is the statistics of a host answered the probes sent to broadcast
+// or multicast address
+type ResponderStatistics struct {
+	// Addr is the address of host
+	Addr string
+	// PacketsRecv is the number of probes answered by the host
+	PacketsRecv int
+	// Duplicates is the number of duplicated replies of the host
+	Duplicates int
+	// Rtts is the round trip time of each answered probe, in receiving order
+	Rtts []time.Duration
+	// MinRtt is the minimum round trip time
+	MinRtt time.Duration
+	// AvgRtt is the average round trip time
+	AvgRtt time.Duration
+	// MaxRtt is the maximum round trip time
+	MaxRtt time.Duration
+	// MdevRtt is the standard deviation of round trip time
+	MdevRtt time.Duration
+}
+
+// initBroadcast checks whether target is a broadcast or multicast address. Like iputils,
+// pinging ipv4 broadcast address must be enabled explicitly by the Broadcast option.
+func (p *Pinger) initBroadcast() error {
+	ip := p.resolvedTargetAddr.IP
+	isBroadcast := utils.IsBroadcast(ip)
+	if isBroadcast && !p.Broadcast {
+		return fmt.Errorf("do you want to ping broadcast? Then -b. If not, check your local firewall rules")
+	}
+	p.broadcastTarget = isBroadcast || ip.IsMulticast()
+	if !p.broadcastTarget {
+		return nil
+	}
+	if p.TCP || p.UDP || p.PMTU || p.ICMPTimestamp {
+		return fmt.Errorf("broadcast and multicast targets are only supported by icmp echo")
+	}
+
+	// link local multicast address is scoped by the interface
+	if p.ipProtocolVersion == 6 && p.resolvedTargetAddr.Zone == "" && ip.IsLinkLocalMulticast() &&
+		p.Interface != "" && net.ParseIP(p.Interface) == nil {
+		p.resolvedTargetAddr.Zone = p.Interface
+	}
+	return nil
+}
+
+// setMulticastOptions sets the ttl and interface of multicast probes, the ttl of multicast
+// defaults to 1 instead of the ttl of unicast.
+func (p *Pinger) setMulticastOptions(c *icmp.PacketConn) error {
+	if !p.resolvedTargetAddr.IP.IsMulticast() {
+		return nil
+	}
+	var intf *net.Interface
+	if p.Interface != "" && net.ParseIP(p.Interface) == nil {
+		var err error
+		if intf, err = net.InterfaceByName(p.Interface); err != nil {
+			return err
+		}
+	}
+
+	if p.ipProtocolVersion == 4 {
+		if p.TTL > 0 {
+			if err := c.IPv4PacketConn().SetMulticastTTL(p.TTL); err != nil {
+				return err
+			}
+		}
+		if intf != nil {
+			return c.IPv4PacketConn().SetMulticastInterface(intf)
+		}
+		return nil
+	}
+	if p.TTL > 0 {
+		if err := c.IPv6PacketConn().SetMulticastHopLimit(p.TTL); err != nil {
+			return err
+		}
+	}
+	if intf != nil {
+		return c.IPv6PacketConn().SetMulticastInterface(intf)
+	}
+	return nil
+}
+
+// matchResponderReply matches a reply of the probe sent to broadcast or multicast address,
+// which may be answered by many hosts. The probe is received when the first host answers,
+// the replies of other hosts are counted in their own statistics instead of duplicates.
+// It returns nil if no probe of the sequence is sent.
+func (p *Pinger) matchResponderReply(seq int, addr string) *matchedReply {
+	p.mu.Lock()
+	pr, ok := p.probes[seq]
+	if !ok {
+		p.mu.Unlock()
+		p.debugLogger.V(4).Info("reply of unknown sequence", "seq", seq)
+		return nil
+	}
+
+	reply := &matchedReply{
+		probe: pr,
+		rtt:   time.Since(pr.sentAt),
+	}
+	r := p.responder(addr)
+	switch {
+	case pr.responders[addr]:
+		r.duplicates++
+		p.duplicatePackets++
+		reply.duplicate = true
+		reply.flag = " (DUP!)"
+	case reply.rtt > p.WaitTime:
+		p.latePackets++
+		reply.late = true
+		reply.flag = " (late, counted as lost)"
+	default:
+		if pr.responders == nil {
+			pr.responders = make(map[string]bool)
+		}
+		pr.responders[addr] = true
+		r.rtts = append(r.rtts, reply.rtt)
+		if !pr.received {
+			p.receiveProbe(reply)
+		}
+	}
+	p.mu.Unlock()
+
+	if reply.answered {
+		p.notifyReplied()
+	}
+	return reply
+}
+
+// responder returns the responder of addr, a new one is added if it's not found. p.mu
+// must be held.
+func (p *Pinger) responder(addr string) *responder {
+	for _, r := range p.responders {
+		if r.addr == addr {
+			return r
+		}
+	}
+	r := &responder{addr: addr}
+	p.responders = append(p.responders, r)
+	return r
+}
+
+// responderStatistics returns the statistics of responders and the number of hosts
+// answered each probe in sending order. p.mu must be held.
+func (p *Pinger) responderStatistics() ([]ResponderStatistics, []int) {
+	stats := make([]ResponderStatistics, 0, len(p.responders))
+	for _, r := range p.responders {
+		s := ResponderStatistics{
+			Addr:        r.addr,
+			PacketsRecv: len(r.rtts),
+			Duplicates:  r.duplicates,
+			Rtts:        append([]time.Duration(nil), r.rtts...),
+		}
+		s.MinRtt, s.AvgRtt, s.MaxRtt, s.MdevRtt = summarizeRtts(s.Rtts)
+		stats = append(stats, s)
+	}
+
+	probes := make([]*probe, 0, len(p.probes))
+	for _, pr := range p.probes {
+		probes = append(probes, pr)
+	}
+	sort.Slice(probes, func(i, j int) bool { return probes[i].sentAt.Before(probes[j].sentAt) })
+	rounds := make([]int, len(probes))
+	for i, pr := range probes {
+		rounds[i] = len(pr.responders)
+	}
+	return stats, rounds
+}
@@ -0,0 +1,306 @@
+package ping
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+
+	"github.com/joyme123/gnt/utils"
+)
+
+const (
+	// icmpTypeSourceQuench is deprecated by RFC 6633, and is not defined in ipv4 package
+	icmpTypeSourceQuench ipv4.ICMPType = 4
+	// ndOptRedirectedHeader is the neighbor discovery option carrying the original packet
+	// of icmpv6 redirect, RFC 4861
+	ndOptRedirectedHeader = 4
+)
+
+// ICMPError is an icmp error message received for a probe
+type ICMPError struct {
+	// Seq is the sequence of probe quoted in the message
+	Seq int
+	// Addr is the address of host which sent the message
+	Addr string
+	// Protocol is the protocol of message, 1 for icmp and 58 for icmpv6
+	Protocol int
+	// Type is the icmp type of message
+	Type int
+	// Code is the icmp code of message
+	Code int
+	// Name is the description of type and code, e.g. "Destination Port Unreachable"
+	Name string
+	// Gateway is the new next hop advertised by redirect
+	Gateway net.IP
+	// MTU is the mtu of next hop advertised by "fragmentation needed" or "packet too big"
+	MTU int
+	// Pointer is the offset of octet where the parameter problem was detected
+	Pointer int
+}
+
+// Advisory returns true for redirect and source quench, the probe is not discarded by
+// the host sending them, so they are not counted as errors.
+func (e *ICMPError) Advisory() bool {
+	if e.Protocol == icmpProtocol(6) {
+		return e.Type == int(ipv6.ICMPTypeRedirect)
+	}
+	return e.Type == int(ipv4.ICMPTypeRedirect) || e.Type == int(icmpTypeSourceQuench)
+}
+
+// ICMPError decodes the icmp error message. It returns nil if the packet is not an error.
+// The sequence is not set because it depends on the protocol of probe.
+func (pkt *Packet) ICMPError() *ICMPError {
+	rm := pkt.Message
+	e := &ICMPError{
+		Addr: utils.IPAddrString(pkt.Addr),
+		Code: rm.Code,
+	}
+
+	switch typ := rm.Type.(type) {
+	case ipv4.ICMPType:
+		e.Protocol, e.Type = icmpProtocol(4), int(typ)
+		switch typ {
+		case ipv4.ICMPTypeDestinationUnreachable:
+			e.MTU = pkt.NextHopMTU()
+		case ipv4.ICMPTypeRedirect:
+			if body, ok := rm.Body.(*icmp.RawBody); ok && len(body.Data) >= 4 {
+				e.Gateway = net.IP(append([]byte(nil), body.Data[0:4]...))
+			}
+		case ipv4.ICMPTypeParameterProblem:
+			if body, ok := rm.Body.(*icmp.ParamProb); ok {
+				e.Pointer = int(body.Pointer)
+			}
+		case icmpTypeSourceQuench, ipv4.ICMPTypeTimeExceeded:
+		default:
+			return nil
+		}
+		e.Name = icmpErrorName(typ, rm.Code, e)
+	case ipv6.ICMPType:
+		e.Protocol, e.Type = icmpProtocol(6), int(typ)
+		switch typ {
+		case ipv6.ICMPTypePacketTooBig:
+			e.MTU = pkt.NextHopMTU()
+		case ipv6.ICMPTypeRedirect:
+			// reserved(4), target(16) and destination(16)
+			if body, ok := rm.Body.(*icmp.RawBody); ok && len(body.Data) >= 20 {
+				e.Gateway = net.IP(append([]byte(nil), body.Data[4:20]...))
+			}
+		case ipv6.ICMPTypeParameterProblem:
+			if body, ok := rm.Body.(*icmp.ParamProb); ok {
+				e.Pointer = int(body.Pointer)
+			}
+		case ipv6.ICMPTypeDestinationUnreachable, ipv6.ICMPTypeTimeExceeded:
+		default:
+			return nil
+		}
+		e.Name = icmpv6ErrorName(typ, rm.Code, e)
+	default:
+		return nil
+	}
+	return e
+}
+
+// icmpErrorName returns the name of icmpv4 error like iputils
+func icmpErrorName(typ ipv4.ICMPType, code int, e *ICMPError) string {
+	var names map[int]string
+	switch typ {
+	case ipv4.ICMPTypeDestinationUnreachable:
+		if code == fragmentationNeeded {
+			return fmt.Sprintf("Frag needed and DF set (mtu = %d)", e.MTU)
+		}
+		names = map[int]string{
+			0:  "Destination Net Unreachable",
+			1:  "Destination Host Unreachable",
+			2:  "Destination Protocol Unreachable",
+			3:  "Destination Port Unreachable",
+			5:  "Source Route Failed",
+			6:  "Destination Net Unknown",
+			7:  "Destination Host Unknown",
+			8:  "Source Host Isolated",
+			9:  "Destination Net Prohibited",
+			10: "Destination Host Prohibited",
+			11: "Destination Net Unreachable for Type of Service",
+			12: "Destination Host Unreachable for Type of Service",
+			13: "Communication administratively prohibited",
+			14: "Host Precedence Violation",
+			15: "Precedence Cutoff in effect",
+		}
+	case icmpTypeSourceQuench:
+		names = map[int]string{0: "Source Quench"}
+	case ipv4.ICMPTypeRedirect:
+		names = map[int]string{
+			0: "Redirect Network",
+			1: "Redirect Host",
+			2: "Redirect Type of Service and Network",
+			3: "Redirect Type of Service and Host",
+		}
+		if name, ok := names[code]; ok {
+			return fmt.Sprintf("%s (New nexthop: %s)", name, e.Gateway)
+		}
+	case ipv4.ICMPTypeTimeExceeded:
+		names = map[int]string{
+			0: "Time to live exceeded",
+			1: "Frag reassembly time exceeded",
+		}
+	case ipv4.ICMPTypeParameterProblem:
+		names = map[int]string{
+			0: fmt.Sprintf("Parameter problem: pointer = %d", e.Pointer),
+			1: "Parameter problem: missing a required option",
+			2: "Parameter problem: bad length",
+		}
+	}
+	if name, ok := names[code]; ok {
+		return name
+	}
+	if typ == icmpTypeSourceQuench {
+		return fmt.Sprintf("Source Quench, Bad Code: %d", code)
+	}
+	return fmt.Sprintf("%s, Bad Code: %d", typ, code)
+}
+
+// icmpv6ErrorName returns the name of icmpv6 error like iputils
+func icmpv6ErrorName(typ ipv6.ICMPType, code int, e *ICMPError) string {
+	var names map[int]string
+	switch typ {
+	case ipv6.ICMPTypeDestinationUnreachable:
+		names = map[int]string{
+			0: "No route",
+			1: "Administratively prohibited",
+			2: "Beyond scope of source address",
+			3: "Address unreachable",
+			4: "Port unreachable",
+			5: "Source address failed ingress/egress policy",
+			6: "Reject route to destination",
+			7: "Error in source routing header",
+		}
+	case ipv6.ICMPTypePacketTooBig:
+		return fmt.Sprintf("Packet too big (mtu = %d)", e.MTU)
+	case ipv6.ICMPTypeTimeExceeded:
+		names = map[int]string{
+			0: "Time to live exceeded",
+			1: "Frag reassembly time exceeded",
+		}
+	case ipv6.ICMPTypeParameterProblem:
+		names = map[int]string{
+			0: "Parameter problem: erroneous header field",
+			1: "Parameter problem: unrecognized next header",
+			2: "Parameter problem: unrecognized ipv6 option",
+			3: "Parameter problem: incomplete header chain",
+		}
+		if name, ok := names[code]; ok {
+			return fmt.Sprintf("%s, pointer = %d", name, e.Pointer)
+		}
+	case ipv6.ICMPTypeRedirect:
+		return fmt.Sprintf("Redirect (New nexthop: %s)", e.Gateway)
+	}
+	if name, ok := names[code]; ok {
+		return name
+	}
+	return fmt.Sprintf("%s, Bad Code: %d", typ, code)
+}
+
+// quotedData returns the original datagram quoted in icmp error message, it's nil if the
+// message is not an error.
+func quotedData(rm *icmp.Message) []byte {
+	switch body := rm.Body.(type) {
+	case *icmp.DstUnreach:
+		return body.Data
+	case *icmp.TimeExceeded:
+		return body.Data
+	case *icmp.ParamProb:
+		return body.Data
+	case *icmp.PacketTooBig:
+		return body.Data
+	case *icmp.RawBody:
+		switch rm.Type {
+		case ipv4.ICMPTypeRedirect, icmpTypeSourceQuench:
+			// the gateway or unused field is followed by the original datagram
+			if len(body.Data) >= 4 {
+				return body.Data[4:]
+			}
+		case ipv6.ICMPTypeRedirect:
+			return redirectedHeader(body.Data)
+		}
+	}
+	return nil
+}
+
+// redirectedHeader returns the original packet in the redirected header option of icmpv6
+// redirect, the options follow reserved(4), target(16) and destination(16).
+func redirectedHeader(b []byte) []byte {
+	if len(b) < 36 {
+		return nil
+	}
+	opts := b[36:]
+	for len(opts) >= 8 {
+		l := int(opts[1]) * 8
+		if l == 0 || l > len(opts) {
+			return nil
+		}
+		if opts[0] == ndOptRedirectedHeader {
+			// type(1), length(1) and reserved(6)
+			return opts[8:l]
+		}
+		opts = opts[l:]
+	}
+	return nil
+}
+
+// processICMPError handles the icmp error message of echo request, the probe is matched
+// by the id and sequence in quoted icmp header.
+func (p *Pinger) processICMPError(pkt *Packet) {
+	icmpErr := pkt.ICMPError()
+	if icmpErr == nil {
+		p.debugLogger.V(4).Info("not an icmp error", "type", pkt.Message.Type)
+		return
+	}
+	data := quotedData(pkt.Message)
+	if p.UDP {
+		p.processUDPError(pkt, icmpErr, data)
+		return
+	}
+
+	quoted := ParseQuotedPacket(data)
+	if quoted == nil || quoted.Protocol != icmpProtocol(p.ipProtocolVersion) || len(quoted.Payload) < 8 ||
+		!quoted.Dst.Equal(p.resolvedTargetAddr.IP) {
+		return
+	}
+	p.debugLogger.V(4).Info("process icmp error", "data", fmt.Sprintf("%x", quoted.Payload), "name", icmpErr.Name)
+
+	id := int(binary.BigEndian.Uint16(quoted.Payload[4:6]))
+	if !p.matchID(p.id, id) {
+		return
+	}
+	icmpErr.Seq = int(binary.BigEndian.Uint16(quoted.Payload[6:8]))
+	p.addICMPError(icmpErr)
+
+	p.log.Printf("From %s icmp_seq=%d %s\n", icmpErr.Addr, icmpErr.Seq, icmpErr.Name)
+}
+
+// addICMPError records the error, which is counted unless it's advisory, and passes it to
+// the callback of its type.
+func (p *Pinger) addICMPError(icmpErr *ICMPError) {
+	p.mu.Lock()
+	if !icmpErr.Advisory() {
+		p.errorPackets++
+	}
+	p.icmpErrors = append(p.icmpErrors, *icmpErr)
+	p.mu.Unlock()
+
+	callback := p.OnReceiveICMPError
+	switch {
+	case icmpErr.Type == int(ipv4.ICMPTypeTimeExceeded) && icmpErr.Protocol == icmpProtocol(4),
+		icmpErr.Type == int(ipv6.ICMPTypeTimeExceeded) && icmpErr.Protocol == icmpProtocol(6):
+		callback = p.OnReceiveTTLExceeded
+	case icmpErr.Type == int(ipv4.ICMPTypeDestinationUnreachable) && icmpErr.Protocol == icmpProtocol(4),
+		icmpErr.Type == int(ipv6.ICMPTypeDestinationUnreachable) && icmpErr.Protocol == icmpProtocol(6):
+		callback = p.OnReceiveDestinationUnreachable
+	}
+	if callback != nil {
+		callback(icmpErr)
+	}
+}
@@ -0,0 +1,186 @@
+package ping
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// ipv4 option types, RFC 791
+const (
+	ipOptEOL       = 0
+	ipOptNOP       = 1
+	ipOptRR        = 7
+	ipOptTimestamp = 68
+)
+
+// flags of timestamp option
+const (
+	// ipOptTSOnly records timestamps only
+	ipOptTSOnly = 0
+	// ipOptTSAndAddr records the address and timestamp of each hop
+	ipOptTSAndAddr = 1
+)
+
+// Values of TimestampOption
+const (
+	TimestampOnly    = "tsonly"
+	TimestampAndAddr = "tsandaddr"
+)
+
+// IPOption is a decoded record route or timestamp option of ipv4 header
+type IPOption struct {
+	// Type is the option type
+	Type int
+	// Route is the recorded route, or the addresses of timestamps
+	Route []net.IP
+	// Timestamps are the milliseconds since midnight UT recorded by hops
+	Timestamps []uint32
+	// Overflow is the number of hops which can't record timestamp because of no space
+	Overflow int
+}
+
+// ipOptions builds the ipv4 options of echo request
+func (p *Pinger) ipOptions() []byte {
+	var opts []byte
+	switch {
+	case p.RecordRoute:
+		// type, length, pointer and 9 addresses
+		opts = make([]byte, 3+9*4)
+		opts[0], opts[1], opts[2] = ipOptRR, byte(len(opts)), 4
+	case p.TimestampOption == TimestampOnly:
+		// type, length, pointer, overflow and flags, and 9 timestamps
+		opts = make([]byte, 4+9*4)
+		opts[0], opts[1], opts[2], opts[3] = ipOptTimestamp, byte(len(opts)), 5, ipOptTSOnly
+	case p.TimestampOption == TimestampAndAddr:
+		// type, length, pointer, overflow and flags, and 4 address and timestamp pairs
+		opts = make([]byte, 4+4*8)
+		opts[0], opts[1], opts[2], opts[3] = ipOptTimestamp, byte(len(opts)), 5, ipOptTSAndAddr
+	default:
+		return nil
+	}
+
+	// options are padded to 4 bytes with end of option list
+	for len(opts)%4 != 0 {
+		opts = append(opts, ipOptEOL)
+	}
+	return opts
+}
+
+// parseIPOptions decodes the record route and timestamp options, the other options are skipped
+func parseIPOptions(b []byte) ([]IPOption, error) {
+	var opts []IPOption
+	for len(b) > 0 {
+		switch b[0] {
+		case ipOptEOL:
+			return opts, nil
+		case ipOptNOP:
+			b = b[1:]
+			continue
+		}
+		if len(b) < 2 || int(b[1]) < 2 || int(b[1]) > len(b) {
+			return opts, fmt.Errorf("truncated ip option %d", b[0])
+		}
+		opt, data := b[:b[1]], b[b[1]:]
+		b = data
+
+		switch opt[0] {
+		case ipOptRR:
+			if len(opt) < 3 {
+				return opts, fmt.Errorf("invalid record route option")
+			}
+			end := optionEnd(opt)
+			o := IPOption{Type: ipOptRR}
+			for i := 3; i+4 <= end; i += 4 {
+				o.Route = append(o.Route, net.IP(append([]byte(nil), opt[i:i+4]...)))
+			}
+			opts = append(opts, o)
+		case ipOptTimestamp:
+			if len(opt) < 4 {
+				return opts, fmt.Errorf("invalid timestamp option")
+			}
+			end := optionEnd(opt)
+			o := IPOption{Type: ipOptTimestamp, Overflow: int(opt[3] >> 4)}
+			step := 4
+			if opt[3]&0x0f != ipOptTSOnly {
+				step = 8
+			}
+			for i := 4; i+step <= end; i += step {
+				if step == 8 {
+					o.Route = append(o.Route, net.IP(append([]byte(nil), opt[i:i+4]...)))
+				}
+				o.Timestamps = append(o.Timestamps, binary.BigEndian.Uint32(opt[i+step-4:i+step]))
+			}
+			opts = append(opts, o)
+		}
+	}
+	return opts, nil
+}
+
+// optionEnd returns the end of recorded data, the pointer of option is 1-based and points
+// to the first free slot.
+func optionEnd(opt []byte) int {
+	end := int(opt[2]) - 1
+	if end > len(opt) {
+		end = len(opt)
+	}
+	return end
+}
+
+// String formats the option like iputils, the first timestamp is absolute and the others
+// are relative to the previous one.
+func (o IPOption) String() string {
+	var sb strings.Builder
+	switch o.Type {
+	case ipOptRR:
+		sb.WriteString("RR:")
+		for _, ip := range o.Route {
+			fmt.Fprintf(&sb, "\t%s\n", ip)
+		}
+	case ipOptTimestamp:
+		sb.WriteString("TS:")
+		for i, ts := range o.Timestamps {
+			if len(o.Route) > i {
+				fmt.Fprintf(&sb, "\t%s", o.Route[i])
+			}
+			if i == 0 {
+				fmt.Fprintf(&sb, "\t%d absolute\n", ts)
+			} else {
+				fmt.Fprintf(&sb, "\t%d\n", int64(ts)-int64(o.Timestamps[i-1]))
+			}
+		}
+		if o.Overflow > 0 {
+			fmt.Fprintf(&sb, "\t(%d hops not recorded)\n", o.Overflow)
+		}
+	}
+	if sb.Len() > 0 && !strings.HasSuffix(sb.String(), "\n") {
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
+
+// printIPOptions prints the options of echo reply. The same route as the previous reply
+// is not printed again.
+func (p *Pinger) printIPOptions(pkt *Packet) {
+	if pkt.Header == nil || len(pkt.Header.Options) == 0 {
+		return
+	}
+	opts, err := parseIPOptions(pkt.Header.Options)
+	if err != nil {
+		p.debugLogger.V(4).Info("parse ip options failed", "err", err.Error())
+	}
+	for _, opt := range opts {
+		s := opt.String()
+		if opt.Type == ipOptRR {
+			p.mu.Lock()
+			same := s == p.lastRoute
+			p.lastRoute = s
+			p.mu.Unlock()
+			if same {
+				s = "(same route)\n"
+			}
+		}
+		p.log.Print(s)
+	}
+}
@@ -0,0 +1,297 @@
+package ping
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/joyme123/gnt/utils"
+)
+
+// MultiPinger pings many targets at the same time, like fping. Targets of the same
+// address family share one icmp connection, replies are dispatched to the pinger
+// of each target by the source address(or the destination of quoted packet for
+// icmp error messages). Targets resolving to the same address are pinged once.
+type MultiPinger struct {
+	// Pinger is the template of options for each target, its TargetAddr is ignored
+	*Pinger
+	// Targets are the target host addresses
+	Targets []string
+	// Rate is the max number of probes sent per second to all targets, 0 means no limit
+	Rate float64
+	// Concurrency is the max number of targets pinged at the same time, 0 means no limit.
+	// A target is pinged until its Count or Timeout is reached.
+	Concurrency int
+
+	pingers []*Pinger
+}
+
+func (m *MultiPinger) Run(ctx context.Context) ([]*Statistics, error) {
+	if len(m.Targets) == 0 {
+		return nil, fmt.Errorf("target address must be specified")
+	}
+	m.initPingers()
+
+	var cancel context.CancelFunc
+	ctx, cancel = context.WithCancel(ctx)
+	defer cancel()
+
+	if m.TCP || m.UDP || m.PMTU {
+		return m.runEach(ctx)
+	}
+
+	var slots chan struct{}
+	if m.Concurrency > 0 {
+		slots = make(chan struct{}, m.Concurrency)
+	}
+
+	var senders, receivers errgroup.Group
+	for _, version := range []int{4, 6} {
+		var pingers []*Pinger
+		for _, p := range m.pingers {
+			if p.resolvedTargetAddr != nil && p.ipProtocolVersion == version {
+				pingers = append(pingers, p)
+			}
+		}
+		if len(pingers) == 0 {
+			continue
+		}
+
+		c, err := pingers[0].listen()
+		if err != nil {
+			return m.statistics(), err
+		}
+		defer c.Close()
+		if err := pingers[0].setTTL(c); err != nil {
+			return m.statistics(), err
+		}
+		// the id may be assigned by the connection
+		for _, p := range pingers[1:] {
+			p.id = pingers[0].id
+		}
+
+		dispatch := dispatchPackets(pingers)
+		for _, p := range pingers {
+			p.dispatch = dispatch
+		}
+		receivers.Go(func() error {
+			return m.receive(ctx, c, pingers[0])
+		})
+		for i, p := range pingers {
+			p := p
+			if slots != nil {
+				senders.Go(func() error {
+					select {
+					case <-ctx.Done():
+						return nil
+					case slots <- struct{}{}:
+					}
+					defer func() { <-slots }()
+					return p.Send(ctx, c)
+				})
+				continue
+			}
+			// spread the probes of different targets over the interval
+			delay := p.Interval * time.Duration(i) / time.Duration(len(pingers))
+			senders.Go(func() error {
+				select {
+				case <-ctx.Done():
+					return nil
+				case <-time.After(delay):
+				}
+				return p.Send(ctx, c)
+			})
+		}
+	}
+
+	err := senders.Wait()
+	cancel()
+	if rerr := receivers.Wait(); err == nil {
+		err = rerr
+	}
+	return m.statistics(), err
+}
+
+// runEach runs tcp, udp or path mtu probes for all targets, each target uses its own sockets
+func (m *MultiPinger) runEach(ctx context.Context) ([]*Statistics, error) {
+	var g errgroup.Group
+	if m.Concurrency > 0 {
+		g.SetLimit(m.Concurrency)
+	}
+	for _, p := range m.pingers {
+		if p.resolvedTargetAddr == nil {
+			continue
+		}
+		p := p
+		g.Go(func() error {
+			var err error
+			if p.PMTU {
+				_, err = p.runPMTU(ctx)
+			} else if p.TCP {
+				_, err = p.runTCP(ctx)
+			} else {
+				_, err = p.runUDP(ctx)
+			}
+			return err
+		})
+	}
+	err := g.Wait()
+	return m.statistics(), err
+}
+
+func (m *MultiPinger) initPingers() {
+	var limiter *rateLimiter
+	if m.Rate > 0 {
+		limiter = &rateLimiter{interval: time.Duration(float64(time.Second) / m.Rate)}
+	}
+	m.pingers = make([]*Pinger, 0, len(m.Targets))
+	// the replies are dispatched by address, so the targets of the same address are pinged once
+	seen := make(map[string]string, len(m.Targets))
+	for _, target := range m.Targets {
+		p := &Pinger{
+			Count:                           m.Count,
+			Interval:                        m.Interval,
+			Interface:                       m.Interface,
+			Timestamp:                       m.Timestamp,
+			Quite:                           m.Quite,
+			TTL:                             m.TTL,
+			Timeout:                         m.Timeout,
+			Network:                         m.Network,
+			Deadline:                        m.Deadline,
+			Preload:                         m.Preload,
+			Flood:                           m.Flood,
+			Adaptive:                        m.Adaptive,
+			TCP:                             m.TCP,
+			UDP:                             m.UDP,
+			UDPData:                         m.UDPData,
+			UDPExpect:                       m.UDPExpect,
+			Port:                            m.Port,
+			Size:                            m.Size,
+			Pattern:                         m.Pattern,
+			RandomPayload:                   m.RandomPayload,
+			WaitTime:                        m.WaitTime,
+			TargetAddr:                      target,
+			Unprivileged:                    m.Unprivileged,
+			SocketOptions:                   m.SocketOptions,
+			PMTU:                            m.PMTU,
+			RecordRoute:                     m.RecordRoute,
+			TimestampOption:                 m.TimestampOption,
+			ICMPTimestamp:                   m.ICMPTimestamp,
+			log:                             m.log,
+			debugLogger:                     m.debugLogger,
+			OnReceiveEchoReply:              m.OnReceiveEchoReply,
+			OnReceiveTTLExceeded:            m.OnReceiveTTLExceeded,
+			OnReceiveDestinationUnreachable: m.OnReceiveDestinationUnreachable,
+			OnReceiveTimestampReply:         m.OnReceiveTimestampReply,
+			OnReceiveICMPError:              m.OnReceiveICMPError,
+			limiter:                         limiter,
+		}
+		if err := p.initDefaultOptions(); err != nil {
+			p.log.Printf("%s: %v\n", target, err)
+			p.resolvedTargetAddr = nil
+		} else if first, ok := seen[p.resolvedTargetAddr.String()]; ok {
+			p.log.Printf("%s: duplicate of %s, skipped\n", target, first)
+			continue
+		} else {
+			seen[p.resolvedTargetAddr.String()] = target
+		}
+		m.pingers = append(m.pingers, p)
+	}
+}
+
+// dispatchPackets returns the function dispatching the packets of shared connection to the
+// pinger of each target
+func dispatchPackets(pingers []*Pinger) func(pkt *Packet) {
+	targets := make(map[string]*Pinger, len(pingers))
+	for _, p := range pingers {
+		targets[p.resolvedTargetAddr.IP.String()] = p
+	}
+	return func(pkt *Packet) {
+		if p, ok := targets[packetTarget(pkt)]; ok {
+			p.processICMPPacket(pkt)
+		}
+	}
+}
+
+// receive reads the packets of shared connection by reader, and dispatches them
+func (m *MultiPinger) receive(ctx context.Context, c *icmp.PacketConn, reader *Pinger) error {
+	stop := unblockReadOnDone(ctx, c)
+	defer stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		default:
+			pkt, err := reader.readPacket(c)
+			if err != nil {
+				return err
+			}
+			if pkt == nil {
+				continue
+			}
+			reader.dispatch(pkt)
+		}
+	}
+}
+
+func (m *MultiPinger) statistics() []*Statistics {
+	stats := make([]*Statistics, 0, len(m.pingers))
+	for _, p := range m.pingers {
+		stats = append(stats, p.Statistics())
+	}
+	return stats
+}
+
+// packetTarget returns the target address which the packet belongs to. It's the source
+// of echo reply, or the destination of the original datagram quoted in icmp error message.
+func packetTarget(pkt *Packet) string {
+	switch pkt.Message.Body.(type) {
+	case *icmp.Echo, *ICMPTimestamp:
+		return utils.IPAddrString(pkt.Addr)
+	}
+
+	if quoted := ParseQuotedPacket(quotedData(pkt.Message)); quoted != nil {
+		return quoted.Dst.String()
+	}
+	return ""
+}
+
+// rateLimiter spaces the probes of pingers sharing it by the interval
+type rateLimiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	// next is the earliest time to send the next probe
+	next time.Time
+}
+
+// wait waits until the next probe can be sent, it returns immediately if l is nil
+func (l *rateLimiter) wait(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	wait := l.next.Sub(now)
+	l.next = l.next.Add(l.interval)
+	l.mu.Unlock()
+	if wait <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
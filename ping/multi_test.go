@@ -0,0 +1,22 @@
+package ping
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMultiPinger_initPingers(t *testing.T) {
+	m := &MultiPinger{
+		Pinger:  &Pinger{},
+		Targets: []string{"10.0.0.1", "10.0.0.2", "10.0.0.1", "fd00::1", "fd00:0::1"},
+	}
+	m.initPingers()
+
+	var targets []string
+	for _, p := range m.pingers {
+		targets = append(targets, p.TargetAddr)
+	}
+	if want := []string{"10.0.0.1", "10.0.0.2", "fd00::1"}; !reflect.DeepEqual(targets, want) {
+		t.Errorf("pinged targets %v, want %v", targets, want)
+	}
+}
@@ -0,0 +1,56 @@
+package ping
+
+import (
+	"crypto/rand"
+	"fmt"
+	"time"
+)
+
+const (
+	// maxPayloadSizeIPv4 is 65535 - 20(ipv4 header) - 8(icmp header)
+	maxPayloadSizeIPv4 = 65507
+	// maxPayloadSizeIPv6 is 65535 - 8(icmp header), ipv6 payload length doesn't include its header
+	maxPayloadSizeIPv6 = 65527
+	// maxHeadersLen is the space reserved for ip and icmp headers when reading packets
+	maxHeadersLen = 60 + 8
+)
+
+// payload builds the data of echo request. It starts with the 8 bytes sending time if
+// there is enough space, the rest is filled with random bytes, pattern or offset.
+func (p *Pinger) payload(sentAt time.Time) []byte {
+	data := make([]byte, p.Size)
+	offset := 0
+	if p.Size >= 8 {
+		copy(data, timeToBytes(sentAt))
+		offset = 8
+	}
+
+	switch {
+	case p.RandomPayload:
+		_, _ = rand.Read(data[offset:])
+	case len(p.Pattern) > 0:
+		for i := offset; i < len(data); i++ {
+			data[i] = p.Pattern[(i-offset)%len(p.Pattern)]
+		}
+	default:
+		for i := offset; i < len(data); i++ {
+			data[i] = uint8(i)
+		}
+	}
+
+	return data
+}
+
+// verifyPayload compares the echoed data with the data sent, and describes the first
+// corrupted byte. It returns empty string if the data is intact.
+func verifyPayload(sent, received []byte) string {
+	for i := range sent {
+		if i >= len(received) {
+			return fmt.Sprintf("truncated reply, %d bytes received, %d bytes sent", len(received), len(sent))
+		}
+		if sent[i] != received[i] {
+			return fmt.Sprintf("wrong data byte #%d should be 0x%x but was 0x%x", i, sent[i], received[i])
+		}
+	}
+	return ""
+}
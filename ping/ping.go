@@ -5,10 +5,12 @@ import (
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"io"
 	"log"
-	"math"
 	"net"
 	"os"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/go-logr/logr"
@@ -16,15 +18,20 @@ import (
 	"golang.org/x/net/ipv4"
 	"golang.org/x/net/ipv6"
 	"golang.org/x/sync/errgroup"
+
+	"github.com/joyme123/gnt/utils"
 )
 
+// adaptiveMinInterval is the minimal interval between probes in adaptive mode
+const adaptiveMinInterval = 10 * time.Millisecond
+
 // Pinger is an implement of ping command
 type Pinger struct {
 	// Count is times to send icmp/udp packets
 	Count int
-	// Interval is the interval to send packets
-	Interval int
-	// Interface is the network interface to send packets
+	// Interval is the interval to send packets. Defaults to 1 second, or 10 milliseconds in flood mode.
+	Interval time.Duration
+	// Interface is the network interface or the source address to send packets
 	Interface string
 	// Timestamp indicates whether to print timestamp before each line
 	Timestamp bool
@@ -32,19 +39,72 @@ type Pinger struct {
 	Quite bool
 	// TTL set the IP time to live
 	TTL int
-	// Timeout is the total seconds to wait for sending packets
-	Timeout int
+	// Timeout is the total time to wait for sending packets
+	Timeout time.Duration
 	// Network options: ip(select automatically), ip4, ip6
 	Network string
 
-	Deadline int
+	// Deadline is the deadline of each read and write on the connection
+	Deadline time.Duration
+
+	// Preload is the number of packets sent back to back before the normal pacing starts
+	Preload int
+	// Flood sends the next packet as soon as a reply comes back, or after the interval
+	// if no reply comes back in time.
+	Flood bool
+	// TCP sends tcp syn to Port instead of icmp echo request, and measures the time until
+	// syn-ack or rst comes back.
+	TCP bool
+	// UDP sends udp datagrams to Port instead of icmp echo request, and measures the time until
+	// the reply or icmp port unreachable comes back.
+	UDP bool
+	// UDPData is the data of udp probes. Defaults to the same data as icmp echo request.
+	UDPData []byte
+	// UDPExpect is the data expected in the reply of udp probes. If it's empty, the reply
+	// must be the same as the data sent, like the echo protocol(RFC 862).
+	UDPExpect []byte
+	// Port is the destination port of tcp/udp probes. Defaults to 80 for tcp and 7(echo) for udp.
+	Port int
+
+	// Adaptive adapts the interval to round trip time, so that effectively not more than one
+	// (or more if preload is set) unanswered probe is present in the network.
+	Adaptive bool
+
+	// Size is the number of data bytes to be sent, 0 is valid. The ping command defaults
+	// to 56, which translates into 64 icmp data bytes with the 8 bytes of icmp header.
+	Size int
+	// Pattern is the bytes to fill the data of packet. The data is filled with its offset by default.
+	Pattern []byte
+	// RandomPayload fills the data of packet with random bytes
+	RandomPayload bool
+
+	// WaitTime is the time to wait for a reply, replies arrive later are counted as lost.
+	// Defaults to 10 seconds.
+	WaitTime time.Duration
 
 	// TargetAddr is the target host address
 	TargetAddr string
 
-	// FIXME(jpf): can't receive ttl exceeded response on linux
+	// Unprivileged uses datagram icmp socket instead of raw socket
+	// FIXME(jpf): can't receive ttl exceeded response of udp and tcp probes on linux, the
+	// icmp errors are sent to the socket of each probe instead of icmp socket
 	Unprivileged bool
 
+	// PMTU discovers the path mtu to target instead of pinging
+	PMTU bool
+
+	// RecordRoute sets the record route option of ipv4, the route is printed under each reply
+	RecordRoute bool
+	// TimestampOption sets the timestamp option of ipv4: tsonly or tsandaddr
+	TimestampOption string
+
+	// ICMPTimestamp sends icmp timestamp request instead of echo request, and estimates
+	// the clock offset of target from the timestamps of reply
+	ICMPTimestamp bool
+
+	// SocketOptions are the tos, firewall mark, bound device and path mtu discovery of probe sockets
+	utils.SocketOptions
+
 	log         *log.Logger
 	debugLogger *logr.Logger
 
@@ -57,27 +117,149 @@ type Pinger struct {
 	id       int
 	sequence int
 
-	sendPackets          int64
-	receivePackets       int64
-	minLatency           int
-	maxLatency           int
-	avgLatency           int
-	sumOfSquareLatency   int64
+	// mu protects the metrics below, they are updated by both sending and receiving goroutines
+	mu                   sync.Mutex
+	sendPackets          int
+	receivePackets       int
+	errorPackets         int
+	duplicatePackets     int
+	corruptedPackets     int
+	latePackets          int
+	reorderedPackets     int
+	rtts                 []time.Duration
 	firstPacketTimestamp time.Time
+	lastPacketTimestamp  time.Time
+	// ewmaRtt is the exponential weighted moving average of rtt
+	ewmaRtt time.Duration
+	// probes are the sent probes indexed by sequence
+	probes map[int]*probe
+	// lastAnsweredProbe is the latest sent probe which has been answered
+	lastAnsweredProbe *probe
+	// replied is notified when a probe gets its reply
+	replied chan struct{}
+	// probing tracks the running probes which wait for their replies on their own
+	probing sync.WaitGroup
+	// udpProbes are the sequences of udp probes indexed by their source ports
+	udpProbes map[int]int
+	// listeningICMP is true if icmp errors of udp probes are received by icmp connection
+	listeningICMP bool
+	// udpSourceAddr is the local address of udp probes
+	udpSourceAddr *net.UDPAddr
+
+	// pathMTU is the path mtu discovered
+	pathMTU int
+	// mtuHops are the hops advertised smaller mtu
+	mtuHops []MTUHop
+	// lastRoute is the route recorded in the last reply
+	lastRoute string
+	// icmpErrors are the icmp errors received for probes
+	icmpErrors []ICMPError
+	// broadcastTarget is true if target is a broadcast or multicast address, which may
+	// be answered by many hosts
+	broadcastTarget bool
+	// responders are the hosts answered broadcast or multicast probes, in the order of
+	// their first replies
+	responders []*responder
+	// limiter limits the rate of probes shared with other pingers, it's nil if no limit
+	limiter *rateLimiter
+	// clockOffsets are the clock offsets of target estimated from timestamp replies
+	clockOffsets []time.Duration
+	// dispatch handles the packets read from the connection shared with other pingers, it's
+	// nil if the connection isn't shared
+	dispatch func(pkt *Packet)
+
+	// The callbacks below are called after the packet is matched with its probe and counted
+	// in the statistics, the packets of unknown probes are dropped without calling them.
+	OnReceiveEchoReply              func(reply *Reply)
+	OnReceiveTimestampReply         func(reply *Reply)
+	OnReceiveTTLExceeded            func(icmpErr *ICMPError)
+	OnReceiveDestinationUnreachable func(icmpErr *ICMPError)
+	// OnReceiveICMPError is called for the icmp errors other than destination unreachable
+	// and time exceeded, e.g. redirect, parameter problem, source quench and packet too big
+	OnReceiveICMPError func(icmpErr *ICMPError)
+	// HandlePacket replaces the built-in processing of received packets if it's set, so the
+	// packets of probes sent by others can be handled, e.g. by traceroute. The packets are
+	// neither counted in the statistics nor passed to the callbacks above.
+	HandlePacket func(pkt *Packet)
+}
 
-	OnReceiveEchoReply              func(rm *icmp.Message, n int, ip net.Addr, ttl int)
-	OnReceiveTTLExceeded            func(rm *icmp.Message, ip net.Addr)
-	OnReceiveDestinationUnreachable func(rm *icmp.Message, ip net.Addr)
+// Reply is a reply matched with its probe
+type Reply struct {
+	// Seq is the sequence of probe
+	Seq int
+	// RTT is the round trip time of probe
+	RTT time.Duration
+	// TTL is the ttl(ipv4) or hop limit(ipv6) of reply, it's -1 if unknown
+	TTL int
+	// Addr is the address of host which sent the reply
+	Addr net.Addr
+	// Bytes is the length of icmp message
+	Bytes int
+	// Duplicate is true if the probe has been answered before
+	Duplicate bool
+	// Late is true if the reply arrives after WaitTime, the probe is counted as lost
+	Late bool
+	// Packet is the received packet
+	Packet *Packet
 }
 
-func (p *Pinger) Run(ctx context.Context) error {
+// probe is an echo request sent to target
+type probe struct {
+	// sentAt is the time when the probe is sent, with monotonic clock reading
+	sentAt   time.Time
+	received bool
+	// responders are the hosts answered the probe sent to broadcast or multicast address
+	responders map[string]bool
+	// payload is the random data sent, it's only kept for random payload because other
+	// payload can be generated again from sentAt.
+	payload []byte
+}
+
+// Packet is an icmp packet received from the network
+type Packet struct {
+	// Message is the parsed icmp message
+	Message *icmp.Message
+	// Bytes is the length of the icmp message
+	Bytes int
+	// Addr is the address which sent the packet
+	Addr net.Addr
+	// TTL is the ttl(hop limit for ipv6) of the packet, -1 if unknown
+	TTL int
+	// Raw is the icmp message as received
+	Raw []byte
+	// Header is the ipv4 header of packet, it's nil if the header is not received
+	Header *ipv4.Header
+}
+
+// Run sends and receives packets until the context is done or the Count/Timeout is reached,
+// and returns the statistics of this run.
+func (p *Pinger) Run(ctx context.Context) (*Statistics, error) {
+	if p.PMTU {
+		return p.runPMTU(ctx)
+	}
+	if p.TCP || p.UDP {
+		if err := p.initDefaultOptions(); err != nil {
+			return nil, err
+		}
+		if p.TCP {
+			return p.runTCP(ctx)
+		}
+		return p.runUDP(ctx)
+	}
+
 	c, err := p.Listen(ctx)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	defer c.Close()
 
 	if err := p.setTTL(c); err != nil {
-		return err
+		return nil, err
+	}
+	if p.broadcastTarget {
+		if err := p.setMulticastOptions(c); err != nil {
+			return nil, err
+		}
 	}
 
 	var cancel context.CancelFunc
@@ -93,8 +275,7 @@ func (p *Pinger) Run(ctx context.Context) error {
 		return p.Send(ctx, c)
 	})
 	err = g.Wait()
-	p.printSummary()
-	return err
+	return p.Statistics(), err
 }
 
 func (p *Pinger) Listen(ctx context.Context) (*icmp.PacketConn, error) {
@@ -102,6 +283,10 @@ func (p *Pinger) Listen(ctx context.Context) (*icmp.PacketConn, error) {
 		return nil, err
 	}
 
+	return p.listen()
+}
+
+func (p *Pinger) listen() (*icmp.PacketConn, error) {
 	network, err := p.network()
 	if err != nil {
 		return nil, err
@@ -117,154 +302,434 @@ func (p *Pinger) Listen(ctx context.Context) (*icmp.PacketConn, error) {
 	if err != nil {
 		return nil, err
 	}
+	if err := p.initConn(c); err != nil {
+		c.Close()
+		return nil, err
+	}
+	rc, err := p.rawConn(c)
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	if err := p.SocketOptions.Apply(rc, p.ipProtocolVersion == 6); err != nil {
+		c.Close()
+		return nil, err
+	}
+	if opts := p.ipOptions(); opts != nil {
+		if err := setIPOptions(rc, opts); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
 
 	return c, nil
 }
 
 func (p *Pinger) Send(ctx context.Context, c *icmp.PacketConn) error {
-	interval := time.NewTicker(time.Duration(p.Interval) * time.Second)
-	defer interval.Stop()
+	for i := 0; i < p.Preload && p.continueToPing(); i++ {
+		if err := p.sendProbe(ctx, c); err != nil {
+			return err
+		}
+	}
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for p.continueToPing() {
+		var replied <-chan struct{}
+		if p.Flood || p.Adaptive {
+			replied = p.replied
+		}
 
-	for {
 		select {
 		case <-ctx.Done():
 			return nil
-		case <-interval.C:
-			if !p.continueToPing() {
-				return nil
-			}
-
-			if p.Deadline > 0 {
-				if err := c.SetWriteDeadline(time.Now().Add(time.Second * time.Duration(p.Deadline))); err != nil {
-					return err
+		case <-replied:
+			if p.Adaptive {
+				if err := p.waitMinInterval(ctx); err != nil {
+					return nil
 				}
 			}
+		case <-timer.C:
+		}
 
-			icmpMessage := make([]byte, 0, 56)
-			timeBytes := timeToBytes(time.Now())
-			icmpMessage = append(icmpMessage, timeBytes...)
-			for i := 0x08; i < 48+0x08; i++ {
-				icmpMessage = append(icmpMessage, uint8(i))
-			}
+		// timeout may be reached while waiting
+		if !p.continueToPing() {
+			break
+		}
+		if err := p.sendProbe(ctx, c); err != nil {
+			return err
+		}
 
-			wm := icmp.Message{
-				Code: 0,
-				Body: &icmp.Echo{
-					ID:   p.id,
-					Seq:  p.sequence,
-					Data: icmpMessage,
-				},
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
 			}
-			if p.ipProtocolVersion == 4 {
-				wm.Type = ipv4.ICMPTypeEcho
+		}
+		timer.Reset(p.Interval)
+	}
+
+	if !p.timeoutReached() {
+		p.linger(ctx)
+	}
+	return nil
+}
+
+func (p *Pinger) sendProbe(ctx context.Context, c *icmp.PacketConn) error {
+	if err := p.limiter.wait(ctx); err != nil {
+		// the context is done, the probe is not sent
+		return nil
+	}
+	if p.TCP {
+		p.sendTCPProbe(ctx)
+		return nil
+	}
+	if p.UDP {
+		return p.sendUDPProbe(ctx)
+	}
+
+	if p.Deadline > 0 {
+		if err := c.SetWriteDeadline(time.Now().Add(p.Deadline)); err != nil {
+			return err
+		}
+	}
+
+	seq := p.sequence
+	sentAt := time.Now()
+	var icmpMessage, wb []byte
+	var err error
+	if p.ICMPTimestamp {
+		wb, err = p.timestampRequest(seq, sentAt)
+	} else {
+		icmpMessage = p.payload(sentAt)
+		wb, err = p.echoRequest(seq, icmpMessage)
+	}
+	if err != nil {
+		return err
+	}
+
+	// the probe must be recorded before sending, otherwise the reply may arrive before it
+	p.setSendMetrics(sentAt, icmpMessage)
+	if p.Flood {
+		_, _ = p.log.Writer().Write([]byte("."))
+	}
+	if err := p.writeProbe(c, wb); err != nil {
+		switch {
+		case errors.Is(err, os.ErrDeadlineExceeded):
+			p.log.Printf("Request timeout for icmp_seq %d\n", seq)
+		case errors.Is(err, syscall.EMSGSIZE):
+			// the packet is larger than mtu and fragmentation is prohibited
+			p.mu.Lock()
+			p.errorPackets++
+			p.mu.Unlock()
+			p.log.Printf("local error: message too long, icmp_seq=%d\n", seq)
+		case isICMPError(err):
+			p.mu.Lock()
+			p.errorPackets++
+			p.mu.Unlock()
+			p.log.Printf("local error: %v, icmp_seq=%d\n", err, seq)
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+// writeProbe writes the probe to connection. The icmp errors of previous probes are queued
+// on the unprivileged connection, and the pending one is reported by writing instead of
+// this probe. So the queued errors are handled as received, and the probe is written again.
+func (p *Pinger) writeProbe(c *icmp.PacketConn, b []byte) error {
+	var err error
+	for i := 0; i < 3; i++ {
+		if _, err = c.WriteTo(b, p.targetNetAddr()); !isICMPError(err) {
+			return err
+		}
+		for pkt := p.readErrQueue(c); pkt != nil; pkt = p.readErrQueue(c) {
+			if p.dispatch != nil {
+				p.dispatch(pkt)
 			} else {
-				wm.Type = ipv6.ICMPTypeEchoRequest
+				p.processICMPPacket(pkt)
 			}
+		}
+	}
+	return err
+}
 
-			wb, err := wm.Marshal(nil)
-			if err != nil {
-				return err
-			}
+// isICMPError returns true if err is converted from icmp errors
+func isICMPError(err error) bool {
+	return errors.Is(err, syscall.EHOSTUNREACH) || errors.Is(err, syscall.ENETUNREACH) ||
+		errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.EPROTO)
+}
 
-			var addr net.Addr
-			addr = p.resolvedTargetAddr
-			if p.Unprivileged {
-				addr = &net.UDPAddr{
-					IP: p.resolvedTargetAddr.IP,
-				}
-			}
+// echoRequest builds the icmp echo request
+func (p *Pinger) echoRequest(seq int, data []byte) ([]byte, error) {
+	wm := icmp.Message{
+		Code: 0,
+		Body: &icmp.Echo{
+			ID:   p.id,
+			Seq:  seq,
+			Data: data,
+		},
+	}
+	if p.ipProtocolVersion == 4 {
+		wm.Type = ipv4.ICMPTypeEcho
+	} else {
+		wm.Type = ipv6.ICMPTypeEchoRequest
+	}
 
-			if _, err := c.WriteTo(wb, addr); err != nil {
-				if errors.Is(err, os.ErrDeadlineExceeded) {
-					p.log.Printf("Request timeout for icmp_seq %d\n", p.sequence)
-				} else {
-					return err
-				}
-			}
-			p.setSendMetrics()
+	return wm.Marshal(nil)
+}
+
+// targetNetAddr returns the target address for writing to icmp connection
+func (p *Pinger) targetNetAddr() net.Addr {
+	if p.Unprivileged {
+		return &net.UDPAddr{
+			IP:   p.resolvedTargetAddr.IP,
+			Zone: p.resolvedTargetAddr.Zone,
 		}
 	}
+	return p.resolvedTargetAddr
+}
+
+// waitMinInterval waits until the minimal interval has passed since the last probe
+// is sent, so adaptive mode won't send too fast to a very close target.
+func (p *Pinger) waitMinInterval(ctx context.Context) error {
+	p.mu.Lock()
+	wait := adaptiveMinInterval - time.Since(p.lastPacketTimestamp)
+	p.mu.Unlock()
+	if wait <= 0 {
+		return nil
+	}
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(wait):
+		return nil
+	}
+}
+
+// linger waits for the replies of outstanding probes after the last probe is sent. It waits
+// twice of the max rtt(at least one interval), but no more than the wait time.
+func (p *Pinger) linger(ctx context.Context) {
+	p.mu.Lock()
+	wait := p.Interval
+	for _, rtt := range p.rtts {
+		if 2*rtt > wait {
+			wait = 2 * rtt
+		}
+	}
+	p.mu.Unlock()
+	if wait > p.WaitTime {
+		wait = p.WaitTime
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	// the probes to broadcast or multicast address are answered by unknown number of hosts
+	for p.broadcastTarget || p.outstanding() > 0 {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+			return
+		case <-p.replied:
+		}
+	}
+}
+
+// outstanding returns the number of probes which haven't been answered
+func (p *Pinger) outstanding() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	n := 0
+	for _, pr := range p.probes {
+		if !pr.received {
+			n++
+		}
+	}
+	return n
 }
 
 func (p *Pinger) Receive(ctx context.Context, c *icmp.PacketConn) error {
+	stop := unblockReadOnDone(ctx, c)
+	defer stop()
+
 	for {
 		select {
 		case <-ctx.Done():
 			return nil
 		default:
-			p.debugLogger.V(4).Info("start read packets from connection")
-			if p.Deadline > 0 {
-				if err := c.SetReadDeadline(time.Now().Add(time.Second * time.Duration(p.Deadline))); err != nil {
-					return err
-				}
-			}
-			buf := make([]byte, 1500)
-			var n int
-			var ttl = -1
-			var ip net.Addr
-			var err error
-			if p.ipProtocolVersion == 4 {
-				var cm *ipv4.ControlMessage
-				n, cm, ip, err = c.IPv4PacketConn().ReadFrom(buf)
-				if cm != nil {
-					ttl = cm.TTL
-				}
-			} else {
-				var cm *ipv6.ControlMessage
-				n, cm, ip, err = c.IPv6PacketConn().ReadFrom(buf)
-				if cm != nil {
-					ttl = cm.HopLimit
-				}
-			}
+			pkt, err := p.readPacket(c)
 			if err != nil {
-				if errors.Is(err, os.ErrDeadlineExceeded) {
-					p.debugLogger.V(4).Info("read packets deadline exceeded", "msg", err.Error(), "n", n)
-					time.Sleep(30 * time.Millisecond)
-					continue
-				} else {
-					p.log.Printf("read failed: %v\n", err)
-				}
+				return err
+			}
+			if pkt == nil {
 				continue
 			}
+			p.processICMPPacket(pkt)
+		}
+	}
+}
 
-			p.debugLogger.V(4).Info("receive packet", "ip", ip, "bytes", n, "data", fmt.Sprintf("%x", buf))
+// unblockReadOnDone makes the blocking read on connection return immediately when the
+// context is done. The returned function must be called to release resources.
+func unblockReadOnDone(ctx context.Context, c interface{ SetReadDeadline(time.Time) error }) func() {
+	stopped := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = c.SetReadDeadline(time.Now())
+		case <-stopped:
+		}
+	}()
+	return func() {
+		close(stopped)
+	}
+}
 
-			proto := 1 // icmp v4
-			if p.ipProtocolVersion == 6 {
-				proto = 58 // icmp v6
-			}
+// rawConn returns the raw connection of icmp connection to access socket options
+func (p *Pinger) rawConn(c *icmp.PacketConn) (syscall.RawConn, error) {
+	var pc net.PacketConn
+	if p.ipProtocolVersion == 4 {
+		pc = c.IPv4PacketConn().PacketConn
+	} else {
+		pc = c.IPv6PacketConn().PacketConn
+	}
+	sc, ok := pc.(syscall.Conn)
+	if !ok {
+		return nil, fmt.Errorf("unsupported connection type %T", pc)
+	}
+	return sc.SyscallConn()
+}
 
-			var rm *icmp.Message
-			if rm, err = p.parseMessage(proto, buf); err != nil {
-				return err
+// readPacket reads an icmp packet from connection. It returns nil packet if nothing
+// is read before deadline or the read is failed, and returns error only if the
+// connection is unusable.
+func (p *Pinger) readPacket(c *icmp.PacketConn) (*Packet, error) {
+	p.debugLogger.V(4).Info("start read packets from connection")
+	// icmp errors of unprivileged connection are queued separately
+	if pkt := p.readErrQueue(c); pkt != nil {
+		return pkt, nil
+	}
+	if p.Deadline > 0 {
+		if err := c.SetReadDeadline(time.Now().Add(p.Deadline)); err != nil {
+			return nil, err
+		}
+	}
+	bufSize := 1500
+	if size := p.Size + maxHeadersLen; size > bufSize {
+		bufSize = size
+	}
+	buf := make([]byte, bufSize)
+	var n int
+	var ttl = -1
+	var ip net.Addr
+	var hdr *ipv4.Header
+	var err error
+	if p.ipProtocolVersion == 4 {
+		if ipc, ok := c.IPv4PacketConn().PacketConn.(*net.IPConn); ok {
+			// raw socket receives the ip header, which carries the ttl and options
+			n, hdr, ip, err = p.readIPv4Packet(ipc, buf)
+			if hdr != nil {
+				ttl = hdr.TTL
+			}
+		} else {
+			var cm *ipv4.ControlMessage
+			n, cm, ip, err = c.IPv4PacketConn().ReadFrom(buf)
+			if cm != nil {
+				ttl = cm.TTL
 			}
-			p.processICMPPacket(rm, n, ip, ttl)
 		}
+	} else {
+		var cm *ipv6.ControlMessage
+		n, cm, ip, err = c.IPv6PacketConn().ReadFrom(buf)
+		if cm != nil {
+			ttl = cm.HopLimit
+		}
+	}
+	if err != nil {
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			p.debugLogger.V(4).Info("read packets deadline exceeded", "msg", err.Error(), "n", n)
+		} else if pkt := p.readErrQueue(c); pkt != nil {
+			// the pending icmp error is reported as the error of read
+			return pkt, nil
+		} else {
+			p.log.Printf("read failed: %v\n", err)
+		}
+		return nil, nil
+	}
+
+	p.debugLogger.V(4).Info("receive packet", "ip", ip, "bytes", n, "data", fmt.Sprintf("%x", buf[:n]))
+
+	proto := 1 // icmp v4
+	if p.ipProtocolVersion == 6 {
+		proto = 58 // icmp v6
+	}
+
+	rm, err := p.parseMessage(proto, buf[:n])
+	if err != nil {
+		return nil, err
 	}
+	decodeMessageBody(rm)
+	return &Packet{
+		Message: rm,
+		Bytes:   n,
+		Addr:    ip,
+		TTL:     ttl,
+		Raw:     buf[:n],
+		Header:  hdr,
+	}, nil
 }
 
-func (p *Pinger) processICMPPacket(rm *icmp.Message, n int, ip net.Addr, ttl int) {
+// readIPv4Packet reads an ipv4 packet from raw socket, the ip header is parsed and removed
+// from buf.
+func (p *Pinger) readIPv4Packet(c *net.IPConn, buf []byte) (int, *ipv4.Header, net.Addr, error) {
+	n, _, _, addr, err := c.ReadMsgIP(buf, nil)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	hdr, err := ipv4.ParseHeader(buf[:n])
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	if hdr.Len > n {
+		return 0, nil, nil, fmt.Errorf("truncated ip header, %d bytes received", n)
+	}
+	// the options refer to buf, which is overwritten by the payload
+	hdr.Options = append([]byte(nil), hdr.Options...)
+	return copy(buf, buf[hdr.Len:n]), hdr, addr, nil
+}
+
+func (p *Pinger) processICMPPacket(pkt *Packet) {
+	if p.HandlePacket != nil {
+		p.HandlePacket(pkt)
+		return
+	}
 	// icmp: type(8), code(8), checksum(16), rest of header(32)
+	rm := pkt.Message
 	p.debugLogger.V(4).Info("process icmp packet", "type", rm.Type)
 	if p.ipProtocolVersion == 4 {
 		switch rm.Type {
 		case ipv4.ICMPTypeEchoReply:
-			p.OnReceiveEchoReply(rm, n, ip, ttl)
-		case ipv4.ICMPTypeDestinationUnreachable:
-			p.OnReceiveDestinationUnreachable(rm, ip)
-		case ipv4.ICMPTypeTimeExceeded:
-			p.OnReceiveTTLExceeded(rm, ip)
+			p.processEchoReply(pkt)
+		case ipv4.ICMPTypeTimestampReply:
+			p.processTimestampReply(pkt)
+		case ipv4.ICMPTypeDestinationUnreachable, ipv4.ICMPTypeTimeExceeded,
+			icmpTypeSourceQuench, ipv4.ICMPTypeRedirect, ipv4.ICMPTypeParameterProblem:
+			p.processICMPError(pkt)
 		default:
 			p.debugLogger.V(4).Info("unknown packet type", "type", rm.Type, "message", rm)
 		}
 	} else {
 		switch rm.Type {
 		case ipv6.ICMPTypeEchoReply:
-			p.OnReceiveEchoReply(rm, n, ip, ttl)
-		case ipv6.ICMPTypeDestinationUnreachable:
-			p.OnReceiveDestinationUnreachable(rm, ip)
-		case ipv6.ICMPTypeTimeExceeded:
-			p.OnReceiveTTLExceeded(rm, ip)
+			p.processEchoReply(pkt)
+		case ipv6.ICMPTypeDestinationUnreachable, ipv6.ICMPTypeTimeExceeded,
+			ipv6.ICMPTypePacketTooBig, ipv6.ICMPTypeParameterProblem, ipv6.ICMPTypeRedirect:
+			p.processICMPError(pkt)
 		default:
 			p.debugLogger.V(4).Info("unknown packet type", "type", rm.Type, "message", rm)
 		}
@@ -272,76 +737,163 @@ func (p *Pinger) processICMPPacket(rm *icmp.Message, n int, ip net.Addr, ttl int
 	}
 }
 
-func (p *Pinger) processEchoReply(rm *icmp.Message, n int, ip net.Addr, ttl int) {
-	echo := rm.Body.(*icmp.Echo)
+func (p *Pinger) processEchoReply(pkt *Packet) {
+	echo := pkt.Message.Body.(*icmp.Echo)
 	p.debugLogger.V(4).Info("process echo reply", "ping id", p.id, "reply id", echo.ID)
 	if !p.matchID(p.id, echo.ID) {
 		return
 	}
 
-	sendTime := bytesToTime(echo.Data[0:8])
-	timeMs := int(time.Now().Sub(sendTime).Milliseconds())
-	if p.maxLatency == 0 || p.maxLatency < timeMs {
-		p.maxLatency = timeMs
+	var reply *matchedReply
+	if p.broadcastTarget {
+		reply = p.matchResponderReply(echo.Seq, utils.IPAddrString(pkt.Addr))
+	} else {
+		reply = p.matchReply(echo.Seq)
+	}
+	if reply == nil {
+		return
+	}
+
+	expected := reply.probe.payload
+	if expected == nil {
+		expected = p.payload(reply.probe.sentAt)
 	}
-	if p.minLatency == 0 || p.minLatency > timeMs {
-		p.minLatency = timeMs
+	corruption := verifyPayload(expected, echo.Data)
+	if corruption != "" {
+		p.mu.Lock()
+		p.corruptedPackets++
+		p.mu.Unlock()
+	}
+	if p.OnReceiveEchoReply != nil {
+		p.OnReceiveEchoReply(reply.event(echo.Seq, pkt))
+	}
+
+	if p.printFlood(reply) {
+		return
+	}
+	p.log.Printf("%d bytes from %s: icmp_seq=%d ttl=%d time=%s ms%s\n", pkt.Bytes, pkt.Addr, echo.Seq, pkt.TTL, formatMs(reply.rtt), reply.flag)
+	if corruption != "" {
+		p.log.Println(corruption)
+	}
+	if p.RecordRoute || p.TimestampOption != "" {
+		p.printIPOptions(pkt)
 	}
-	p.avgLatency = int(((p.receivePackets * int64(p.avgLatency)) + int64(timeMs)) / (p.receivePackets + 1))
-	p.sumOfSquareLatency += int64(timeMs * timeMs)
-	p.log.Printf("%d bytes from %s: icmp_seq=%d ttl=%d time=%d ms\n", n, ip, echo.Seq, ttl, timeMs)
-	p.receivePackets++
 }
 
-func (p *Pinger) processDestinationUnreachable(rm *icmp.Message, ip net.Addr) {
-	msg := rm.Body.(*icmp.DstUnreach)
-	var icmpData []byte
-	if p.ipProtocolVersion == 4 {
-		icmpData = msg.Data[ipv4.HeaderLen:]
-	} else {
-		icmpData = msg.Data[ipv6.HeaderLen:]
+// matchedReply is a reply matched with its probe
+type matchedReply struct {
+	probe *probe
+	rtt   time.Duration
+	// flag describes the abnormal reply, e.g. duplicated, late or reordered
+	flag string
+	// answered is true if it's the first reply of probe and arrives in time
+	answered bool
+	// duplicate is true if the probe has been answered before, late is true if the reply
+	// arrives after the wait time
+	duplicate, late bool
+}
+
+// event returns the reply passed to callbacks
+func (r *matchedReply) event(seq int, pkt *Packet) *Reply {
+	return &Reply{
+		Seq:       seq,
+		RTT:       r.rtt,
+		TTL:       pkt.TTL,
+		Addr:      pkt.Addr,
+		Bytes:     pkt.Bytes,
+		Duplicate: r.duplicate,
+		Late:      r.late,
+		Packet:    pkt,
 	}
+}
 
-	p.debugLogger.V(4).Info("", "data", fmt.Sprintf("%x", icmpData), "id", fmt.Sprintf("%x", icmpData[4:6]), "seq", icmpData[6:8])
+// matchReply matches a reply with the probe of sequence and updates the metrics.
+// It returns nil if no probe of the sequence is sent.
+func (p *Pinger) matchReply(seq int) *matchedReply {
+	p.mu.Lock()
+	pr, ok := p.probes[seq]
+	if !ok {
+		p.mu.Unlock()
+		p.debugLogger.V(4).Info("reply of unknown sequence", "seq", seq)
+		return nil
+	}
 
-	id := binary.BigEndian.Uint16(icmpData[4:6])
-	seq := binary.BigEndian.Uint16(icmpData[6:8])
-	if !p.matchID(p.id, int(id)) {
-		return
+	reply := &matchedReply{
+		probe: pr,
+		rtt:   time.Since(pr.sentAt),
+	}
+	switch {
+	case pr.received:
+		p.duplicatePackets++
+		reply.duplicate = true
+		reply.flag = " (DUP!)"
+	case reply.rtt > p.WaitTime:
+		p.latePackets++
+		reply.late = true
+		reply.flag = " (late, counted as lost)"
+	default:
+		p.receiveProbe(reply)
 	}
+	p.mu.Unlock()
 
-	p.log.Printf("From %s icmp_seq=%d Destination unreachable", ip, seq)
+	if reply.answered {
+		p.notifyReplied()
+	}
+	return reply
 }
 
-func (p *Pinger) processTTLExceeded(rm *icmp.Message, ip net.Addr) {
-	msg := rm.Body.(*icmp.TimeExceeded)
-	var icmpData []byte
-	if p.ipProtocolVersion == 4 {
-		icmpData = msg.Data[ipv4.HeaderLen:]
+// receiveProbe marks the probe of reply as received and updates the metrics, p.mu must
+// be held.
+func (p *Pinger) receiveProbe(reply *matchedReply) {
+	pr := reply.probe
+	reply.answered = true
+	pr.received = true
+	p.receivePackets++
+	p.rtts = append(p.rtts, reply.rtt)
+	if p.ewmaRtt == 0 {
+		p.ewmaRtt = reply.rtt
 	} else {
-		icmpData = msg.Data[ipv6.HeaderLen:]
+		p.ewmaRtt = (p.ewmaRtt*7 + reply.rtt) / 8
 	}
+	if p.lastAnsweredProbe != nil && pr.sentAt.Before(p.lastAnsweredProbe.sentAt) {
+		p.reorderedPackets++
+		reply.flag = " (reordered)"
+	} else {
+		p.lastAnsweredProbe = pr
+	}
+}
 
-	p.debugLogger.V(4).Info("", "data", fmt.Sprintf("%x", icmpData), "id", fmt.Sprintf("%x", icmpData[4:6]), "seq", icmpData[6:8])
-
-	id := binary.BigEndian.Uint16(icmpData[4:6])
-	seq := binary.BigEndian.Uint16(icmpData[6:8])
-	if !p.matchID(p.id, int(id)) {
-		return
+// notifyReplied notifies the sender that a probe is answered
+func (p *Pinger) notifyReplied() {
+	// notify without blocking, one pending notification is enough to wake up the sender
+	select {
+	case p.replied <- struct{}{}:
+	default:
 	}
+}
 
-	p.log.Printf("From %s icmp_seq=%d Time to live exceeded", ip, seq)
+// printFlood prints a backspace for every reply in flood mode, which erases the dot
+// printed for request. It returns false if not in flood mode.
+func (p *Pinger) printFlood(reply *matchedReply) bool {
+	if !p.Flood {
+		return false
+	}
+	if reply.answered {
+		_, _ = p.log.Writer().Write([]byte("\b \b"))
+	}
+	return true
 }
 
 func (p *Pinger) continueToPing() bool {
-	if p.Timeout != 0 && !p.firstPacketTimestamp.IsZero() {
-		if p.firstPacketTimestamp.Add(time.Second * time.Duration(p.Timeout)).Before(time.Now()) {
-			return false
-		}
+	if p.timeoutReached() {
+		return false
 	}
 
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
 	if p.Count != 0 {
-		if p.sendPackets >= int64(p.Count) {
+		if p.sendPackets >= p.Count {
 			return false
 		}
 	}
@@ -349,11 +901,28 @@ func (p *Pinger) continueToPing() bool {
 	return true
 }
 
-func (p *Pinger) setSendMetrics() {
+func (p *Pinger) timeoutReached() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.Timeout != 0 && !p.firstPacketTimestamp.IsZero() && p.firstPacketTimestamp.Add(p.Timeout).Before(time.Now())
+}
+
+func (p *Pinger) setSendMetrics(sentAt time.Time, payload []byte) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
 	if p.firstPacketTimestamp.IsZero() {
-		p.firstPacketTimestamp = time.Now()
+		p.firstPacketTimestamp = sentAt
 	}
+	p.lastPacketTimestamp = sentAt
 	p.sendPackets++
+	// the sequence may wrap around, the old probe with same sequence is replaced
+	pr := &probe{sentAt: sentAt}
+	if p.RandomPayload {
+		pr.payload = payload
+	}
+	p.probes[p.sequence] = pr
 
 	p.sequence++
 	if float64(p.sequence) >= 65535 {
@@ -361,20 +930,22 @@ func (p *Pinger) setSendMetrics() {
 	}
 }
 
-func (p *Pinger) printSummary() {
-	p.log.Printf("\n--- %s ping statistics ---\n", p.resolvedTargetAddr.IP.String())
-	p.log.Printf("%d packets transmitted, %d packets received, %d%% packet loss\n",
-		p.sendPackets, p.receivePackets, int(float64(p.sendPackets-p.receivePackets)/float64(p.sendPackets)*100))
-
-	mdev := 0.0
-	if p.receivePackets > 0 {
-		mdev = math.Sqrt(float64((p.sumOfSquareLatency / p.receivePackets) - int64(p.avgLatency*p.avgLatency)))
-	}
-	p.log.Printf("round-trip min/avg/max/mdev = %d/%d/%d/%.3f ms\n", p.minLatency, p.avgLatency, p.maxLatency, mdev)
-}
-
 func (p *Pinger) initDefaultOptions() error {
 	p.sequence = 1
+	p.probes = make(map[int]*probe)
+	p.replied = make(chan struct{}, 1)
+	p.udpProbes = make(map[int]int)
+
+	if p.Interval == 0 {
+		p.Interval = time.Second
+		if p.Flood {
+			p.Interval = 10 * time.Millisecond
+		}
+	}
+
+	if p.WaitTime == 0 {
+		p.WaitTime = 10 * time.Second
+	}
 
 	if err := p.resolveTargetAddr(); err != nil {
 		return err
@@ -386,6 +957,37 @@ func (p *Pinger) initDefaultOptions() error {
 		p.ipProtocolVersion = 6
 	}
 
+	if p.Port == 0 {
+		p.Port = 80
+		if p.UDP {
+			p.Port = 7
+		}
+	}
+	maxSize := maxPayloadSizeIPv4
+	if p.ipProtocolVersion == 6 {
+		maxSize = maxPayloadSizeIPv6
+	}
+	if p.Size < 0 || p.Size > maxSize {
+		return fmt.Errorf("invalid packet size %d, valid size: 0-%d", p.Size, maxSize)
+	}
+	if err := p.SocketOptions.Validate(); err != nil {
+		return err
+	}
+	switch p.TimestampOption {
+	case "", TimestampOnly, TimestampAndAddr:
+	default:
+		return fmt.Errorf("invalid timestamp option %q, valid values: tsonly, tsandaddr", p.TimestampOption)
+	}
+	if (p.RecordRoute || p.TimestampOption != "") && (p.ipProtocolVersion != 4 || p.Unprivileged || p.TCP || p.UDP) {
+		return fmt.Errorf("record route and timestamp options are only supported by privileged ipv4 icmp")
+	}
+	if p.ICMPTimestamp && (p.ipProtocolVersion != 4 || p.Unprivileged || p.TCP || p.UDP || p.PMTU) {
+		return fmt.Errorf("icmp timestamp is only supported by privileged ipv4 icmp")
+	}
+	if err := p.initBroadcast(); err != nil {
+		return err
+	}
+
 	if p.Network == "" || p.Network == "ip" {
 		if p.ipProtocolVersion == 4 {
 			p.Network = "ip4"
@@ -397,19 +999,13 @@ func (p *Pinger) initDefaultOptions() error {
 	p.id = os.Getpid() & 0xffff
 
 	if p.log == nil {
-		p.log = log.Default()
-	}
-	p.log.SetFlags(0)
-
-	if p.OnReceiveEchoReply == nil {
-		p.OnReceiveEchoReply = p.processEchoReply
-	}
-	if p.OnReceiveTTLExceeded == nil {
-		p.OnReceiveTTLExceeded = p.processTTLExceeded
+		p.log = log.New(io.Discard, "", 0)
 	}
-	if p.OnReceiveDestinationUnreachable == nil {
-		p.OnReceiveDestinationUnreachable = p.processDestinationUnreachable
+	if p.debugLogger == nil {
+		discard := logr.Discard()
+		p.debugLogger = &discard
 	}
+	p.log.SetFlags(0)
 
 	return nil
 }
@@ -422,55 +1018,24 @@ func (p *Pinger) SetDebugLogger(log logr.Logger) {
 	p.debugLogger = &log
 }
 
-func (p *Pinger) getAddrByInterface() (string, error) {
-	if p.Interface == "" {
-		if p.ipProtocolVersion == 4 {
-			// ipv4
-			conn, err := net.Dial("udp", "1.1.1.1:80")
-			if err != nil {
-				return "", err
-			}
-			defer conn.Close()
-			return conn.LocalAddr().(*net.UDPAddr).IP.To4().String(), nil
-		}
-		// ipv6
-		conn, err := net.Dial("udp", "[2606:4700:4700::1111]:80")
-		if err != nil {
-			return "", err
-		}
-		defer conn.Close()
-		return conn.LocalAddr().(*net.UDPAddr).IP.To16().String(), nil
-	}
+// ID returns the id of echo requests, which is the local port of unprivileged connection
+// on linux. It's valid after Listen.
+func (p *Pinger) ID() int {
+	return p.id
+}
 
-	intf, err := net.InterfaceByName(p.Interface)
-	if err != nil {
-		return "", err
-	}
-	addrs, err := intf.Addrs()
+// getAddrByInterface returns the source address to reach target. The interface option
+// can be an interface name or an ip address.
+func (p *Pinger) getAddrByInterface() (string, error) {
+	src, err := utils.SourceAddr(p.resolvedTargetAddr.IP, p.Interface)
 	if err != nil {
 		return "", err
 	}
-
-	if len(addrs) > 0 {
-		ipnet := addrs[0].String()
-		ip, _, err := net.ParseCIDR(ipnet)
-		if err != nil {
-			return "", err
-		}
-
-		if p.resolvedTargetAddr.IP.To4() != nil {
-			// ipv4
-			if ip.To4() != nil {
-				return ip.To4().String(), nil
-			}
-		} else {
-			if ip.To16() != nil {
-				return ip.To16().String(), nil
-			}
-		}
+	// link local address can only be bound with its zone
+	if src.IsLinkLocalUnicast() && src.To4() == nil && p.Interface != "" && net.ParseIP(p.Interface) == nil {
+		return src.String() + "%" + p.Interface, nil
 	}
-
-	return "", fmt.Errorf("interface %s doesn't have any ip address", p.Interface)
+	return src.String(), nil
 }
 
 func (p *Pinger) resolveTargetAddr() error {
@@ -478,7 +1043,15 @@ func (p *Pinger) resolveTargetAddr() error {
 		return fmt.Errorf("target address must be specified")
 	}
 
-	ip, err := net.ResolveIPAddr(p.Network, p.TargetAddr)
+	network := p.Network
+	if src := net.ParseIP(p.Interface); src != nil && (network == "" || network == "ip") {
+		// resolve the target in the family of source address
+		network = "ip6"
+		if src.To4() != nil {
+			network = "ip4"
+		}
+	}
+	ip, err := net.ResolveIPAddr(network, p.TargetAddr)
 	if err != nil {
 		return err
 	}
@@ -521,10 +1094,3 @@ func timeToBytes(t time.Time) []byte {
 	binary.BigEndian.PutUint32(b[4:], uint32(usec))
 	return b
 }
-
-func bytesToTime(b []byte) time.Time {
-	sec := binary.BigEndian.Uint32(b[0:4])
-	usec := binary.BigEndian.Uint32(b[4:])
-
-	return time.Unix(int64(sec), int64(usec)*1000)
-}
@@ -1,17 +1,24 @@
 package ping
 
-import "testing"
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+)
 
 func TestPinger_network(t *testing.T) {
 	type fields struct {
 		Unprivileged bool
 		Count        int
-		Interval     int
+		Interval     time.Duration
 		Interface    string
 		Timestamp    bool
 		Quite        bool
 		TTL          int
-		Timeout      int
+		Timeout      time.Duration
 		Network      string
 	}
 	tests := []struct {
@@ -81,3 +88,431 @@ func TestPinger_network(t *testing.T) {
 		})
 	}
 }
+
+func TestPinger_processEchoReply(t *testing.T) {
+	tests := []struct {
+		name string
+		// replies are sequences of replies in receiving order
+		replies       []int
+		wantReceived  int
+		wantDuplicate int
+		wantReordered int
+	}{
+		{
+			name:         "in order",
+			replies:      []int{1, 2, 3},
+			wantReceived: 3,
+		},
+		{
+			name:          "duplicate",
+			replies:       []int{1, 1, 2},
+			wantReceived:  2,
+			wantDuplicate: 1,
+		},
+		{
+			name:          "reordered",
+			replies:       []int{2, 1, 3},
+			wantReceived:  3,
+			wantReordered: 1,
+		},
+		{
+			name:         "unknown sequence",
+			replies:      []int{1, 9},
+			wantReceived: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var callbacks, duplicates int
+			p := &Pinger{
+				TargetAddr: "127.0.0.1",
+				OnReceiveEchoReply: func(reply *Reply) {
+					callbacks++
+					if reply.Duplicate {
+						duplicates++
+					}
+				},
+			}
+			if err := p.initDefaultOptions(); err != nil {
+				t.Fatal(err)
+			}
+			sentAt := time.Now()
+			for i := 0; i < 3; i++ {
+				p.setSendMetrics(sentAt.Add(time.Duration(i)*time.Millisecond), nil)
+			}
+			for _, seq := range tt.replies {
+				var data []byte
+				if pr, ok := p.probes[seq]; ok {
+					data = p.payload(pr.sentAt)
+				}
+				p.processICMPPacket(&Packet{
+					Message: &icmp.Message{
+						Type: ipv4.ICMPTypeEchoReply,
+						Body: &icmp.Echo{ID: p.id, Seq: seq, Data: data},
+					},
+					Addr: p.resolvedTargetAddr,
+				})
+			}
+
+			s := p.Statistics()
+			if s.PacketsRecv != tt.wantReceived || s.Duplicates != tt.wantDuplicate || s.Reordered != tt.wantReordered {
+				t.Errorf("processEchoReply() received/duplicates/reordered = %d/%d/%d, want %d/%d/%d",
+					s.PacketsRecv, s.Duplicates, s.Reordered, tt.wantReceived, tt.wantDuplicate, tt.wantReordered)
+			}
+			if callbacks != tt.wantReceived+tt.wantDuplicate || duplicates != tt.wantDuplicate {
+				t.Errorf("OnReceiveEchoReply() calls/duplicates = %d/%d, want %d/%d",
+					callbacks, duplicates, tt.wantReceived+tt.wantDuplicate, tt.wantDuplicate)
+			}
+		})
+	}
+}
+
+func Test_verifyPayload(t *testing.T) {
+	tests := []struct {
+		name     string
+		sent     []byte
+		received []byte
+		want     string
+	}{
+		{
+			name:     "intact",
+			sent:     []byte{0x01, 0x02, 0x03},
+			received: []byte{0x01, 0x02, 0x03},
+			want:     "",
+		},
+		{
+			name:     "wrong byte",
+			sent:     []byte{0x01, 0x02, 0x03},
+			received: []byte{0x01, 0x02, 0xff},
+			want:     "wrong data byte #2 should be 0x3 but was 0xff",
+		},
+		{
+			name:     "truncated",
+			sent:     []byte{0x01, 0x02, 0x03},
+			received: []byte{0x01},
+			want:     "truncated reply, 1 bytes received, 3 bytes sent",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := verifyPayload(tt.sent, tt.received); got != tt.want {
+				t.Errorf("verifyPayload() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPacket_NextHopMTU(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  []byte
+		want int
+	}{
+		{
+			name: "fragmentation needed",
+			raw:  []byte{0x03, 0x04, 0x00, 0x00, 0x00, 0x00, 0x05, 0x78},
+			want: 1400,
+		},
+		{
+			name: "fragmentation needed without mtu",
+			raw:  []byte{0x03, 0x04, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00},
+			want: 0,
+		},
+		{
+			name: "host unreachable",
+			raw:  []byte{0x03, 0x01, 0x00, 0x00, 0x00, 0x00, 0x05, 0x78},
+			want: 0,
+		},
+		{
+			name: "packet too big",
+			raw:  []byte{0x02, 0x00, 0x00, 0x00, 0x00, 0x00, 0x05, 0x00},
+			want: 1280,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			proto := 1
+			if tt.raw[0] == 0x02 {
+				proto = 58
+			}
+			rm, err := icmp.ParseMessage(proto, tt.raw)
+			if err != nil {
+				t.Fatalf("ParseMessage() error = %v", err)
+			}
+			pkt := &Packet{Message: rm, Raw: tt.raw}
+			if got := pkt.NextHopMTU(); got != tt.want {
+				t.Errorf("NextHopMTU() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_parseIPOptions(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []byte
+		want string
+	}{
+		{
+			name: "record route",
+			opts: []byte{7, 15, 12, 10, 0, 0, 1, 10, 0, 0, 2, 0, 0, 0, 0, 0},
+			want: "RR:\t10.0.0.1\n\t10.0.0.2\n",
+		},
+		{
+			name: "timestamp only",
+			opts: []byte{68, 16, 13, 0, 0, 0, 0, 100, 0, 0, 0, 103, 0, 0, 0, 0},
+			want: "TS:\t100 absolute\n\t3\n",
+		},
+		{
+			name: "timestamp and address with overflow",
+			opts: []byte{1, 68, 12, 13, 0x21, 10, 0, 0, 1, 0, 0, 0, 100, 0, 0, 0},
+			want: "TS:\t10.0.0.1\t100 absolute\n\t(2 hops not recorded)\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts, err := parseIPOptions(tt.opts)
+			if err != nil {
+				t.Fatalf("parseIPOptions() error = %v", err)
+			}
+			got := ""
+			for _, opt := range opts {
+				got += opt.String()
+			}
+			if got != tt.want {
+				t.Errorf("parseIPOptions() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestICMPTimestamp_EstimateClock(t *testing.T) {
+	midnight := time.Date(2023, 5, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name       string
+		ts         ICMPTimestamp
+		receivedAt time.Time
+		want       *TimestampEstimate
+	}{
+		{
+			name:       "remote clock ahead",
+			ts:         ICMPTimestamp{Originate: 1000, Receive: 1105, Transmit: 1106},
+			receivedAt: midnight.Add(1011 * time.Millisecond),
+			want: &TimestampEstimate{
+				Offset:     100 * time.Millisecond,
+				Forward:    105 * time.Millisecond,
+				Backward:   -95 * time.Millisecond,
+				Processing: time.Millisecond,
+			},
+		},
+		{
+			name:       "midnight passed",
+			ts:         ICMPTimestamp{Originate: 86399990, Receive: 86399995, Transmit: 86399995},
+			receivedAt: midnight.Add(day + 2*time.Millisecond),
+			want: &TimestampEstimate{
+				Offset:   -1 * time.Millisecond,
+				Forward:  5 * time.Millisecond,
+				Backward: 7 * time.Millisecond,
+			},
+		},
+		{
+			name:       "non-standard timestamp",
+			ts:         ICMPTimestamp{Originate: 1000, Receive: nonStandardTimestamp | 5, Transmit: nonStandardTimestamp | 5},
+			receivedAt: midnight.Add(time.Second),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := tt.ts.EstimateClock(tt.receivedAt)
+			if ok != (tt.want != nil) {
+				t.Fatalf("EstimateClock() ok = %v, want %v", ok, tt.want != nil)
+			}
+			if ok && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("EstimateClock() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPacket_ICMPError(t *testing.T) {
+	tests := []struct {
+		name     string
+		proto    int
+		raw      []byte
+		want     string
+		advisory bool
+	}{
+		{
+			name:  "administratively prohibited",
+			proto: 1,
+			raw:   []byte{0x03, 0x0d, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00},
+			want:  "Communication administratively prohibited",
+		},
+		{
+			name:     "redirect host",
+			proto:    1,
+			raw:      []byte{0x05, 0x01, 0x00, 0x00, 0x0a, 0x00, 0x00, 0xfe},
+			want:     "Redirect Host (New nexthop: 10.0.0.254)",
+			advisory: true,
+		},
+		{
+			name:     "source quench",
+			proto:    1,
+			raw:      []byte{0x04, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00},
+			want:     "Source Quench",
+			advisory: true,
+		},
+		{
+			name:  "parameter problem",
+			proto: 1,
+			raw:   []byte{0x0c, 0x00, 0x00, 0x00, 0x14, 0x00, 0x00, 0x00},
+			want:  "Parameter problem: pointer = 20",
+		},
+		{
+			name:  "unknown code",
+			proto: 1,
+			raw:   []byte{0x03, 0x10, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00},
+			want:  "destination unreachable, Bad Code: 16",
+		},
+		{
+			name:  "icmpv6 reject route",
+			proto: 58,
+			raw:   []byte{0x01, 0x06, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00},
+			want:  "Reject route to destination",
+		},
+		{
+			name:  "icmpv6 unrecognized next header",
+			proto: 58,
+			raw:   []byte{0x04, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x06},
+			want:  "Parameter problem: unrecognized next header, pointer = 6",
+		},
+		{
+			name:  "echo reply",
+			proto: 1,
+			raw:   []byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x01},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rm, err := icmp.ParseMessage(tt.proto, tt.raw)
+			if err != nil {
+				t.Fatalf("ParseMessage() error = %v", err)
+			}
+			pkt := &Packet{Message: rm, Raw: tt.raw}
+			got := pkt.ICMPError()
+			if got == nil {
+				if tt.want != "" {
+					t.Fatalf("ICMPError() = nil, want %q", tt.want)
+				}
+				return
+			}
+			if got.Name != tt.want {
+				t.Errorf("ICMPError().Name = %q, want %q", got.Name, tt.want)
+			}
+			if got.Advisory() != tt.advisory {
+				t.Errorf("ICMPError().Advisory() = %v, want %v", got.Advisory(), tt.advisory)
+			}
+		})
+	}
+}
+
+func Test_sweepHosts(t *testing.T) {
+	tests := []struct {
+		name    string
+		cidr    string
+		count   int
+		first   string
+		last    string
+		wantErr bool
+	}{
+		{name: "ipv4 /30", cidr: "10.0.0.1/30", count: 2, first: "10.0.0.1", last: "10.0.0.2"},
+		{name: "ipv4 /31", cidr: "10.0.0.0/31", count: 2, first: "10.0.0.0", last: "10.0.0.1"},
+		{name: "ipv4 /22", cidr: "10.0.0.0/22", count: 1022, first: "10.0.0.1", last: "10.0.3.254"},
+		{name: "ipv6 /120", cidr: "fd00::/120", count: 256, first: "fd00::", last: "fd00::ff"},
+		{name: "too large", cidr: "10.0.0.0/8", wantErr: true},
+		{name: "invalid", cidr: "10.0.0.0", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hosts, err := sweepHosts(tt.cidr)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("sweepHosts() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if len(hosts) != tt.count || hosts[0] != tt.first || hosts[len(hosts)-1] != tt.last {
+				t.Errorf("sweepHosts() = %d hosts %s-%s, want %d hosts %s-%s", len(hosts), hosts[0], hosts[len(hosts)-1], tt.count, tt.first, tt.last)
+			}
+		})
+	}
+}
+
+func TestParseQuotedPacket(t *testing.T) {
+	// ipv6 header from fd00::1 to fd00::2, the next header and payload length are filled
+	ipv6Header := func(next byte, payloadLen int) []byte {
+		h := make([]byte, 40)
+		h[0] = 0x60
+		h[4], h[5] = byte(payloadLen>>8), byte(payloadLen)
+		h[6], h[7] = next, 1
+		h[8], h[9], h[23] = 0xfd, 0x00, 0x01
+		h[24], h[25], h[39] = 0xfd, 0x00, 0x02
+		return h
+	}
+	echo := []byte{0x80, 0x00, 0x00, 0x00, 0x12, 0x34, 0x00, 0x01}
+	concat := func(parts ...[]byte) []byte {
+		var b []byte
+		for _, p := range parts {
+			b = append(b, p...)
+		}
+		return b
+	}
+
+	tests := []struct {
+		name      string
+		data      []byte
+		wantNil   bool
+		wantProto int
+	}{
+		{
+			name:      "no extension header",
+			data:      concat(ipv6Header(58, 8), echo),
+			wantProto: 58,
+		},
+		{
+			name: "hop by hop, destination options and fragment",
+			data: concat(ipv6Header(0, 40),
+				[]byte{60, 0, 1, 4, 0, 0, 0, 0},
+				[]byte{44, 1, 1, 12, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
+				[]byte{58, 0, 0, 1, 0, 0, 0, 1},
+				echo),
+			wantProto: 58,
+		},
+		{
+			name: "authentication header",
+			data: concat(ipv6Header(51, 20),
+				[]byte{17, 1, 0, 0, 0, 0, 0, 1, 0, 0, 0, 1},
+				[]byte{0x82, 0x9a, 0x82, 0x9a, 0x00, 0x08, 0x00, 0x00}),
+			wantProto: 17,
+		},
+		{
+			name:    "truncated extension header",
+			data:    concat(ipv6Header(0, 16), []byte{58, 2, 0, 0, 0, 0, 0, 0}),
+			wantNil: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			quoted := ParseQuotedPacket(tt.data)
+			if (quoted == nil) != tt.wantNil {
+				t.Fatalf("ParseQuotedPacket() = %v, wantNil %v", quoted, tt.wantNil)
+			}
+			if tt.wantNil {
+				return
+			}
+			if quoted.Protocol != tt.wantProto || len(quoted.Payload) != 8 || quoted.Dst.String() != "fd00::2" {
+				t.Errorf("ParseQuotedPacket() = %d %x %s, want %d with 8 bytes payload to fd00::2", quoted.Protocol, quoted.Payload, quoted.Dst, tt.wantProto)
+			}
+		})
+	}
+}
@@ -0,0 +1,318 @@
+package ping
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+
+	"github.com/joyme123/gnt/utils"
+)
+
+const (
+	// fragmentationNeeded is the code of destination unreachable for "fragmentation needed
+	// and DF set"
+	fragmentationNeeded = 4
+	// minMTUIPv4 is the minimal mtu of ipv4, RFC 791
+	minMTUIPv4 = 68
+	// minMTUIPv6 is the minimal mtu of ipv6, RFC 8200
+	minMTUIPv6 = 1280
+	// maxPacketSize is the max size of ip packet
+	maxPacketSize = 65535
+	// pmtuWaitTime is the default time to wait for the answer of path mtu probe
+	pmtuWaitTime = time.Second
+	// pmtuRetries is the number of probes of the same size without answer before the
+	// size is considered too big
+	pmtuRetries = 2
+)
+
+// MTUHop is a hop which advertised a smaller mtu than the probe
+type MTUHop struct {
+	// Addr is the address of hop, it's empty for local host
+	Addr string
+	// MTU is the mtu of next hop advertised
+	MTU int
+}
+
+// MTUProbe is the answer of a path mtu probe
+type MTUProbe struct {
+	// Addr is the address of host which answered the probe, it's empty if there is no
+	// answer or the probe is rejected by local host
+	Addr string
+	// Reached is true if target replied the probe
+	Reached bool
+	// TTLExceeded is true if the probe expired at Addr
+	TTLExceeded bool
+	// TooBig is true if the probe is larger than the mtu of next hop
+	TooBig bool
+	// MTU is the mtu of next hop advertised, it's 0 if unknown
+	MTU int
+	// Unreachable is true if Addr reported target is unreachable
+	Unreachable bool
+	// RTT is the round trip time of probe
+	RTT time.Duration
+}
+
+// Answered returns true if any host answered the probe
+func (r *MTUProbe) Answered() bool {
+	return r.Reached || r.TTLExceeded || r.TooBig || r.Unreachable
+}
+
+// TooBig returns true if the packet is icmp "fragmentation needed" or icmpv6 "packet too big"
+func (pkt *Packet) TooBig() bool {
+	switch pkt.Message.Type {
+	case ipv4.ICMPTypeDestinationUnreachable:
+		return pkt.Message.Code == fragmentationNeeded
+	case ipv6.ICMPTypePacketTooBig:
+		return true
+	}
+	return false
+}
+
+// NextHopMTU returns the mtu of next hop advertised in "fragmentation needed" or "packet
+// too big" message, it's 0 if the mtu is unknown.
+func (pkt *Packet) NextHopMTU() int {
+	if !pkt.TooBig() {
+		return 0
+	}
+	if body, ok := pkt.Message.Body.(*icmp.PacketTooBig); ok {
+		return body.MTU
+	}
+	// RFC 1191: the next hop mtu is in the low-order 16 bits of the unused field
+	if len(pkt.Raw) >= 8 {
+		return int(binary.BigEndian.Uint16(pkt.Raw[6:8]))
+	}
+	return 0
+}
+
+// runPMTU discovers the path mtu to target. DF is set for the probes, the size of probe
+// is reduced to the mtu advertised by "fragmentation needed" or "packet too big", and
+// binary searched if the mtu is not advertised or the probes are silently dropped.
+func (p *Pinger) runPMTU(ctx context.Context) (*Statistics, error) {
+	if p.WaitTime == 0 {
+		p.WaitTime = pmtuWaitTime
+	}
+	// DF must be set and the path mtu known by kernel is ignored
+	if p.PMTUDisc != "" && p.PMTUDisc != utils.PMTUDiscProbe {
+		return nil, fmt.Errorf("path mtu discovery %q conflicts with path mtu probing, which requires %q", p.PMTUDisc, utils.PMTUDiscProbe)
+	}
+	p.PMTUDisc = utils.PMTUDiscProbe
+	c, err := p.Listen(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	minMTU := minMTUIPv4
+	if p.ipProtocolVersion == 6 {
+		minMTU = minMTUIPv6
+	}
+	localMTU := p.RouteMTU()
+	p.log.Printf("pmtu probe to %s, local mtu %d\n", p.resolvedTargetAddr.IP, localMTU)
+
+	// lo is the largest size known to pass, hi is the largest size which may pass
+	lo, hi := 0, localMTU
+	size, tries := hi, 0
+	for lo < hi && ctx.Err() == nil {
+		res, err := p.ProbeMTU(ctx, c, p.TTL, size)
+		if err != nil {
+			return p.Statistics(), err
+		}
+		if ctx.Err() != nil {
+			break
+		}
+
+		switch {
+		case res.Reached:
+			p.log.Printf("size=%d reply from %s time=%s ms\n", size, res.Addr, formatMs(res.RTT))
+			lo, tries = size, 0
+		case res.TooBig:
+			from := res.Addr
+			if from == "" {
+				from = "local host"
+			}
+			p.log.Printf("size=%d %s (mtu = %d) from %s\n", size, tooBigName(p.ipProtocolVersion), res.MTU, from)
+			tries = 0
+			if res.MTU > lo && res.MTU < size {
+				p.addMTUHop(res.Addr, res.MTU)
+				hi, size = res.MTU, res.MTU
+				continue
+			}
+			hi = size - 1
+		case !res.Answered():
+			p.log.Printf("size=%d no reply\n", size)
+			tries++
+			if tries < pmtuRetries {
+				continue
+			}
+			tries = 0
+			hi = size - 1
+		default:
+			return p.Statistics(), fmt.Errorf("%s is not reachable, probe is answered by %s", p.resolvedTargetAddr.IP, res.Addr)
+		}
+
+		if hi < minMTU {
+			break
+		}
+		size = (lo + hi + 1) / 2
+		if size < minMTU {
+			size = minMTU
+		}
+	}
+	if lo == 0 {
+		return p.Statistics(), fmt.Errorf("no reply from %s", p.resolvedTargetAddr.IP)
+	}
+
+	p.mu.Lock()
+	p.pathMTU = lo
+	p.mu.Unlock()
+	return p.Statistics(), nil
+}
+
+// ProbeMTU sends an echo request of size bytes(including ip and icmp headers) with ttl,
+// and waits for the answer until the wait time. ttl is not changed if it's 0. DF should be
+// set to the connection by PMTUDisc option.
+func (p *Pinger) ProbeMTU(ctx context.Context, c *icmp.PacketConn, ttl, size int) (*MTUProbe, error) {
+	headerLen := ipv4.HeaderLen + 8
+	if p.ipProtocolVersion == 6 {
+		headerLen = ipv6.HeaderLen + 8
+	}
+	if size < headerLen || size > maxPacketSize {
+		return nil, fmt.Errorf("invalid probe size %d, valid size: %d-%d", size, headerLen, maxPacketSize)
+	}
+	if ttl > 0 && ttl != p.TTL {
+		p.TTL = ttl
+		if err := p.setTTL(c); err != nil {
+			return nil, err
+		}
+	}
+
+	p.Size = size - headerLen
+	seq := p.sequence
+	sentAt := time.Now()
+	data := p.payload(sentAt)
+	wb, err := p.echoRequest(seq, data)
+	if err != nil {
+		return nil, err
+	}
+	p.setSendMetrics(sentAt, data)
+	if _, err := c.WriteTo(wb, p.targetNetAddr()); err != nil {
+		if errors.Is(err, syscall.EMSGSIZE) {
+			return &MTUProbe{TooBig: true, MTU: p.RouteMTU()}, nil
+		}
+		return nil, err
+	}
+
+	stop := unblockReadOnDone(ctx, c)
+	defer stop()
+
+	deadline := sentAt.Add(p.WaitTime)
+	for ctx.Err() == nil && time.Now().Before(deadline) {
+		if err := c.SetReadDeadline(deadline); err != nil {
+			return nil, err
+		}
+		pkt, err := p.readPacket(c)
+		if err != nil {
+			return nil, err
+		}
+		if pkt == nil {
+			continue
+		}
+		if res := p.mtuProbeAnswer(pkt, seq); res != nil {
+			return res, nil
+		}
+	}
+	return &MTUProbe{}, nil
+}
+
+// mtuProbeAnswer returns the answer if the packet is the reply of probe or the icmp error
+// quoting the probe, otherwise nil.
+func (p *Pinger) mtuProbeAnswer(pkt *Packet, seq int) *MTUProbe {
+	if body, ok := pkt.Message.Body.(*icmp.Echo); ok {
+		if pkt.Message.Type != ipv4.ICMPTypeEchoReply && pkt.Message.Type != ipv6.ICMPTypeEchoReply ||
+			!p.matchID(p.id, body.ID) || body.Seq != seq {
+			return nil
+		}
+		reply := p.matchReply(seq)
+		if reply == nil {
+			return nil
+		}
+		return &MTUProbe{Addr: utils.IPAddrString(pkt.Addr), Reached: true, RTT: reply.rtt}
+	}
+	icmpErr := pkt.ICMPError()
+	if icmpErr == nil || icmpErr.Advisory() {
+		return nil
+	}
+
+	quoted := ParseQuotedPacket(quotedData(pkt.Message))
+	if quoted == nil || quoted.Protocol != icmpProtocol(p.ipProtocolVersion) || len(quoted.Payload) < 8 ||
+		!quoted.Dst.Equal(p.resolvedTargetAddr.IP) {
+		return nil
+	}
+	id := int(binary.BigEndian.Uint16(quoted.Payload[4:6]))
+	if !p.matchID(p.id, id) || int(binary.BigEndian.Uint16(quoted.Payload[6:8])) != seq {
+		return nil
+	}
+
+	icmpErr.Seq = seq
+	p.addICMPError(icmpErr)
+	p.mu.Lock()
+	sentAt := p.probes[seq].sentAt
+	p.mu.Unlock()
+
+	res := &MTUProbe{Addr: utils.IPAddrString(pkt.Addr), RTT: time.Since(sentAt)}
+	switch {
+	case pkt.TooBig():
+		res.TooBig = true
+		res.MTU = pkt.NextHopMTU()
+	case pkt.Message.Type == ipv4.ICMPTypeTimeExceeded || pkt.Message.Type == ipv6.ICMPTypeTimeExceeded:
+		res.TTLExceeded = true
+	default:
+		res.Unreachable = true
+	}
+	return res
+}
+
+// RouteMTU returns the mtu of interface on the route to target, it's 1500 if unknown
+func (p *Pinger) RouteMTU() int {
+	var intf *net.Interface
+	if p.Interface != "" && net.ParseIP(p.Interface) == nil {
+		intf, _ = net.InterfaceByName(p.Interface)
+	}
+	mtu := 1500
+	if r, err := utils.LookupRoute(p.resolvedTargetAddr.IP, intf); err == nil && r.Interface != nil {
+		mtu = r.Interface.MTU
+	}
+	if mtu > maxPacketSize {
+		mtu = maxPacketSize
+	}
+	return mtu
+}
+
+func (p *Pinger) addMTUHop(addr string, mtu int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.mtuHops = append(p.mtuHops, MTUHop{Addr: addr, MTU: mtu})
+}
+
+func icmpProtocol(ipProtocolVersion int) int {
+	if ipProtocolVersion == 6 {
+		return 58
+	}
+	return 1
+}
+
+func tooBigName(ipProtocolVersion int) string {
+	if ipProtocolVersion == 6 {
+		return "Packet too big"
+	}
+	return "Frag needed and DF set"
+}
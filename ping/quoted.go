@@ -0,0 +1,92 @@
+package ping
+
+import (
+	"net"
+
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// udpProtocol is the ip protocol number of udp
+const udpProtocol = 17
+
+// ipv6 extension headers which may precede the upper layer header, RFC 8200
+const (
+	ipv6HopByHop     = 0
+	ipv6Routing      = 43
+	ipv6Fragment     = 44
+	ipv6AuthHeader   = 51
+	ipv6Destinations = 60
+)
+
+// QuotedPacket is the original datagram quoted in icmp error message
+type QuotedPacket struct {
+	// Dst is the destination of original datagram
+	Dst net.IP
+	// Protocol is the upper layer protocol of original datagram
+	Protocol int
+	// Payload is the upper layer data, at least the first 8 bytes are quoted
+	Payload []byte
+}
+
+// ParseQuotedPacket parses the ip header of original datagram quoted in icmp error
+// message, the extension headers of ipv6 are skipped. It returns nil if the data is not
+// a valid ip datagram.
+func ParseQuotedPacket(data []byte) *QuotedPacket {
+	if len(data) >= ipv4.HeaderLen && data[0]>>4 == 4 {
+		hdr, err := ipv4.ParseHeader(data)
+		if err != nil || hdr.Len > len(data) {
+			return nil
+		}
+		return &QuotedPacket{
+			Dst:      hdr.Dst,
+			Protocol: hdr.Protocol,
+			Payload:  data[hdr.Len:],
+		}
+	} else if len(data) >= ipv6.HeaderLen && data[0]>>4 == 6 {
+		hdr, err := ipv6.ParseHeader(data)
+		if err != nil {
+			return nil
+		}
+		proto, payload := skipExtensionHeaders(hdr.NextHeader, data[ipv6.HeaderLen:])
+		if payload == nil {
+			return nil
+		}
+		return &QuotedPacket{
+			Dst:      hdr.Dst,
+			Protocol: proto,
+			Payload:  payload,
+		}
+	}
+	return nil
+}
+
+// skipExtensionHeaders walks the ipv6 extension headers, and returns the upper layer
+// protocol and data. The data is nil if the headers are truncated.
+func skipExtensionHeaders(next int, b []byte) (int, []byte) {
+	for {
+		var l int
+		switch next {
+		case ipv6HopByHop, ipv6Routing, ipv6Destinations:
+			// next header(8), length in 8 octets not including the first 8 octets(8)
+			if len(b) < 2 {
+				return next, nil
+			}
+			l = (int(b[1]) + 1) * 8
+		case ipv6Fragment:
+			l = 8
+		case ipv6AuthHeader:
+			// the length is in 4 octets minus 2
+			if len(b) < 2 {
+				return next, nil
+			}
+			l = (int(b[1]) + 2) * 4
+		default:
+			return next, b
+		}
+		if l > len(b) {
+			return next, nil
+		}
+		next, b = int(b[0]), b[l:]
+	}
+}
@@ -0,0 +1,244 @@
+package ping
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// Statistics is the result of a ping run
+type Statistics struct {
+	// Target is the target host address given by user
+	Target string
+	// Addr is the resolved target address
+	Addr string
+	// PacketsSent is the number of probes sent
+	PacketsSent int
+	// PacketsRecv is the number of probes which got a reply
+	PacketsRecv int
+	// Duplicates is the number of duplicated replies
+	Duplicates int
+	// Late is the number of replies received after the wait time, they are counted as lost
+	Late int
+	// Reordered is the number of replies received after the reply of a later probe
+	Reordered int
+	// Corrupted is the number of replies whose data doesn't match the data sent
+	Corrupted int
+	// Errors is the number of icmp error messages(destination unreachable, time exceeded...) received
+	Errors int
+	// Rtts is the round trip time of each received probe, in receiving order
+	Rtts []time.Duration
+	// MinRtt is the minimum round trip time
+	MinRtt time.Duration
+	// AvgRtt is the average round trip time
+	AvgRtt time.Duration
+	// MaxRtt is the maximum round trip time
+	MaxRtt time.Duration
+	// MdevRtt is the standard deviation of round trip time
+	MdevRtt time.Duration
+	// EwmaRtt is the exponential weighted moving average of round trip time
+	EwmaRtt time.Duration
+	// P50Rtt, P90Rtt, P95Rtt and P99Rtt are the percentiles of round trip time
+	P50Rtt time.Duration
+	P90Rtt time.Duration
+	P95Rtt time.Duration
+	P99Rtt time.Duration
+	// Jitter is the interarrival jitter defined in RFC 3550, the difference of transit
+	// time is the difference of round trip time between consecutive replies
+	Jitter time.Duration
+	// Duration is the time between the first and the last probe sent
+	Duration time.Duration
+	// Ipg is the average inter packet gap
+	Ipg time.Duration
+	// PathMTU is the path mtu discovered, 0 if not in path mtu discovery mode
+	PathMTU int
+	// MTUHops are the hops which advertised smaller mtu, in the order of discovery
+	MTUHops []MTUHop
+	// ICMPErrors are the icmp errors received for probes, in receiving order
+	ICMPErrors []ICMPError
+	// Responders are the statistics of hosts answered the probes sent to broadcast or
+	// multicast address, in the order of their first replies
+	Responders []ResponderStatistics
+	// RoundResponders are the number of hosts answered each probe sent to broadcast or
+	// multicast address, in sending order
+	RoundResponders []int
+	// ClockOffsets are the clock offsets of target estimated from icmp timestamp replies
+	ClockOffsets []time.Duration
+	// MinClockOffset, MedianClockOffset and MaxClockOffset summarize the clock offsets
+	MinClockOffset    time.Duration
+	MedianClockOffset time.Duration
+	MaxClockOffset    time.Duration
+}
+
+// PacketLoss returns the percentage of probes which didn't get a reply
+func (s *Statistics) PacketLoss() float64 {
+	if s.PacketsSent == 0 {
+		return 0
+	}
+	return float64(s.PacketsSent-s.PacketsRecv) / float64(s.PacketsSent) * 100
+}
+
+// Rate returns the achieved sending rate in packets per second
+func (s *Statistics) Rate() float64 {
+	if s.Duration <= 0 {
+		return 0
+	}
+	return float64(s.PacketsSent-1) / s.Duration.Seconds()
+}
+
+// Percentile returns the q-th percentile(0 < q <= 100) of round trip time by nearest rank
+func (s *Statistics) Percentile(q float64) time.Duration {
+	return percentile(sortedRtts(s.Rtts), q)
+}
+
+// HistogramBucket is the number of round trip times in [Low, High)
+type HistogramBucket struct {
+	Low   time.Duration
+	High  time.Duration
+	Count int
+}
+
+// Histogram divides the range of round trip time into equal width buckets, and counts
+// the round trip times in each bucket. The max rtt is counted in the last bucket.
+func (s *Statistics) Histogram(buckets int) []HistogramBucket {
+	if len(s.Rtts) == 0 || buckets <= 0 {
+		return nil
+	}
+
+	width := (s.MaxRtt - s.MinRtt) / time.Duration(buckets)
+	if width <= 0 {
+		// all rtts are the same
+		return []HistogramBucket{{Low: s.MinRtt, High: s.MaxRtt, Count: len(s.Rtts)}}
+	}
+	hist := make([]HistogramBucket, buckets)
+	for i := range hist {
+		hist[i].Low = s.MinRtt + width*time.Duration(i)
+		hist[i].High = hist[i].Low + width
+	}
+	hist[buckets-1].High = s.MaxRtt
+	for _, rtt := range s.Rtts {
+		i := int((rtt - s.MinRtt) / width)
+		if i >= buckets {
+			i = buckets - 1
+		}
+		hist[i].Count++
+	}
+	return hist
+}
+
+// Statistics returns the statistics of packets sent and received so far
+func (p *Pinger) Statistics() *Statistics {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	s := &Statistics{
+		Target:      p.TargetAddr,
+		PacketsSent: p.sendPackets,
+		PacketsRecv: p.receivePackets,
+		Duplicates:  p.duplicatePackets,
+		Late:        p.latePackets,
+		Reordered:   p.reorderedPackets,
+		Corrupted:   p.corruptedPackets,
+		Errors:      p.errorPackets,
+		Rtts:        make([]time.Duration, len(p.rtts)),
+		EwmaRtt:     p.ewmaRtt,
+		Duration:    p.lastPacketTimestamp.Sub(p.firstPacketTimestamp),
+		PathMTU:     p.pathMTU,
+		MTUHops:     append([]MTUHop(nil), p.mtuHops...),
+		ICMPErrors:  append([]ICMPError(nil), p.icmpErrors...),
+	}
+	if p.broadcastTarget {
+		s.Responders, s.RoundResponders = p.responderStatistics()
+	}
+	if len(p.clockOffsets) > 0 {
+		s.ClockOffsets = append([]time.Duration(nil), p.clockOffsets...)
+		sorted := sortedRtts(s.ClockOffsets)
+		s.MinClockOffset = sorted[0]
+		s.MedianClockOffset = percentile(sorted, 50)
+		s.MaxClockOffset = sorted[len(sorted)-1]
+	}
+	if s.PacketsSent > 1 {
+		s.Ipg = s.Duration / time.Duration(s.PacketsSent-1)
+	}
+	if p.resolvedTargetAddr != nil {
+		s.Addr = p.resolvedTargetAddr.IP.String()
+	}
+	copy(s.Rtts, p.rtts)
+
+	if len(s.Rtts) == 0 {
+		return s
+	}
+
+	s.MinRtt, s.AvgRtt, s.MaxRtt, s.MdevRtt = summarizeRtts(s.Rtts)
+
+	sorted := sortedRtts(s.Rtts)
+	s.P50Rtt = percentile(sorted, 50)
+	s.P90Rtt = percentile(sorted, 90)
+	s.P95Rtt = percentile(sorted, 95)
+	s.P99Rtt = percentile(sorted, 99)
+	s.Jitter = jitter(s.Rtts)
+
+	return s
+}
+
+// summarizeRtts returns the minimum, average, maximum and standard deviation of rtts
+func summarizeRtts(rtts []time.Duration) (min, avg, max, mdev time.Duration) {
+	if len(rtts) == 0 {
+		return 0, 0, 0, 0
+	}
+
+	var sum, sumOfSquare float64
+	min, max = rtts[0], rtts[0]
+	for _, rtt := range rtts {
+		if rtt < min {
+			min = rtt
+		}
+		if rtt > max {
+			max = rtt
+		}
+		sum += float64(rtt)
+		sumOfSquare += float64(rtt) * float64(rtt)
+	}
+	n := float64(len(rtts))
+	mean := sum / n
+	// variance may be slightly negative because of float rounding
+	return min, time.Duration(mean), max, time.Duration(math.Sqrt(math.Max(sumOfSquare/n-mean*mean, 0)))
+}
+
+func sortedRtts(rtts []time.Duration) []time.Duration {
+	sorted := make([]time.Duration, len(rtts))
+	copy(sorted, rtts)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return sorted
+}
+
+// percentile returns the q-th percentile of sorted rtts by nearest rank
+func percentile(sorted []time.Duration, q float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(q / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	if rank > len(sorted) {
+		rank = len(sorted)
+	}
+	return sorted[rank-1]
+}
+
+// jitter calculates interarrival jitter as RFC 3550 section 6.4.1, J = J + (|D| - J)/16
+func jitter(rtts []time.Duration) time.Duration {
+	var j float64
+	for i := 1; i < len(rtts); i++ {
+		d := math.Abs(float64(rtts[i] - rtts[i-1]))
+		j += (d - j) / 16
+	}
+	return time.Duration(j)
+}
+
+// formatMs formats duration as milliseconds with microsecond precision
+func formatMs(d time.Duration) string {
+	return fmt.Sprintf("%.3f", float64(d)/float64(time.Millisecond))
+}
@@ -0,0 +1,109 @@
+package ping
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestStatistics_Percentile(t *testing.T) {
+	ms := time.Millisecond
+	tests := []struct {
+		name string
+		rtts []time.Duration
+		q    float64
+		want time.Duration
+	}{
+		{name: "empty", rtts: nil, q: 50, want: 0},
+		{name: "single", rtts: []time.Duration{3 * ms}, q: 99, want: 3 * ms},
+		{name: "median of odd", rtts: []time.Duration{5 * ms, 1 * ms, 3 * ms}, q: 50, want: 3 * ms},
+		{name: "median of even", rtts: []time.Duration{4 * ms, 1 * ms, 3 * ms, 2 * ms}, q: 50, want: 2 * ms},
+		{name: "p90 of ten", rtts: []time.Duration{10 * ms, 9 * ms, 8 * ms, 7 * ms, 6 * ms, 5 * ms, 4 * ms, 3 * ms, 2 * ms, 1 * ms}, q: 90, want: 9 * ms},
+		{name: "p100", rtts: []time.Duration{1 * ms, 7 * ms, 2 * ms}, q: 100, want: 7 * ms},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Statistics{Rtts: tt.rtts}
+			if got := s.Percentile(tt.q); got != tt.want {
+				t.Errorf("Percentile() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPinger_Statistics(t *testing.T) {
+	ms := time.Millisecond
+	tests := []struct {
+		name       string
+		rtts       []time.Duration
+		wantMdev   time.Duration
+		wantJitter time.Duration
+	}{
+		{name: "constant rtt", rtts: []time.Duration{2 * ms, 2 * ms, 2 * ms}, wantMdev: 0, wantJitter: 0},
+		// |D| = 16ms once, J = 16/16
+		{name: "one change", rtts: []time.Duration{0, 16 * ms}, wantMdev: 8 * ms, wantJitter: 1 * ms},
+		// J = 16/16 = 1, then J = 1 + (16-1)/16
+		{name: "alternating", rtts: []time.Duration{0, 16 * ms, 0}, wantMdev: 7542472, wantJitter: 1937500},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &Pinger{rtts: tt.rtts}
+			s := p.Statistics()
+			if s.MdevRtt != tt.wantMdev {
+				t.Errorf("MdevRtt = %v, want %v", s.MdevRtt, tt.wantMdev)
+			}
+			if s.Jitter != tt.wantJitter {
+				t.Errorf("Jitter = %v, want %v", s.Jitter, tt.wantJitter)
+			}
+		})
+	}
+}
+
+func TestStatistics_Histogram(t *testing.T) {
+	ms := time.Millisecond
+	p := &Pinger{rtts: []time.Duration{1 * ms, 2 * ms, 2 * ms, 4 * ms, 5 * ms}}
+	hist := p.Statistics().Histogram(4)
+	want := []int{1, 2, 0, 2}
+	if len(hist) != len(want) {
+		t.Fatalf("len(Histogram()) = %d, want %d", len(hist), len(want))
+	}
+	for i, b := range hist {
+		if b.Count != want[i] {
+			t.Errorf("bucket %d [%v, %v) count = %d, want %d", i, b.Low, b.High, b.Count, want[i])
+		}
+	}
+}
+
+func TestPinger_ResponderStatistics(t *testing.T) {
+	now := time.Now()
+	p := &Pinger{
+		WaitTime:        10 * time.Second,
+		broadcastTarget: true,
+		probes: map[int]*probe{
+			1: {sentAt: now.Add(-2 * time.Second)},
+			2: {sentAt: now.Add(-time.Second)},
+		},
+		replied: make(chan struct{}, 1),
+	}
+	replies := []struct {
+		seq  int
+		addr string
+	}{
+		{1, "10.0.0.2"}, {1, "10.0.0.3"}, {1, "10.0.0.3"}, {2, "10.0.0.3"},
+	}
+	for _, r := range replies {
+		p.matchResponderReply(r.seq, r.addr)
+	}
+
+	s := p.Statistics()
+	if s.PacketsRecv != 2 || s.Duplicates != 1 {
+		t.Errorf("PacketsRecv, Duplicates = %d, %d, want 2, 1", s.PacketsRecv, s.Duplicates)
+	}
+	if len(s.Responders) != 2 || s.Responders[0].Addr != "10.0.0.2" || s.Responders[0].PacketsRecv != 1 ||
+		s.Responders[1].PacketsRecv != 2 || s.Responders[1].Duplicates != 1 {
+		t.Errorf("Responders = %+v", s.Responders)
+	}
+	if !reflect.DeepEqual(s.RoundResponders, []int{2, 1}) {
+		t.Errorf("RoundResponders = %v, want [2 1]", s.RoundResponders)
+	}
+}
@@ -0,0 +1,205 @@
+package ping
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+
+	"github.com/joyme123/gnt/utils"
+)
+
+const (
+	// maxSweepHosts is the max number of hosts in the range of sweep
+	maxSweepHosts = 1 << 16
+	// defaultSweepConcurrency is the default number of hosts pinged at the same time
+	defaultSweepConcurrency = 64
+	// neighborWaitTime is the max time to wait for the neighbor entries being resolved,
+	// which covers the delay and probe of neighbor unreachability detection
+	neighborWaitTime = 10 * time.Second
+	// neighborPollInterval is the interval to check the neighbor table
+	neighborPollInterval = 500 * time.Millisecond
+)
+
+// Sweeper discovers the alive hosts in an address range by pinging all of them. The
+// hosts are pinged by MultiPinger, so the probes are encoded and matched like ping.
+type Sweeper struct {
+	// Pinger is the template of options for each host, Count defaults to 1
+	*Pinger
+	// CIDR is the address range to sweep, e.g. 10.0.0.0/22. The network and broadcast
+	// addresses of ipv4 are skipped.
+	CIDR string
+	// Rate is the max number of probes sent per second, 0 means no limit
+	Rate float64
+	// Concurrency is the max number of hosts pinged at the same time. Defaults to 64.
+	Concurrency int
+	// ResolveNames looks up the names of alive hosts by reverse dns
+	ResolveNames bool
+	// NeighborCache takes the on-link hosts which don't answer ping as alive, if their
+	// entries in the kernel neighbor table are resolved after the sweep. It's a passive
+	// check, no arp or neighbor solicitation is sent by sweeper, the entries are resolved by
+	// kernel for sending the probes. It's only supported on linux.
+	NeighborCache bool
+}
+
+// SweepResult is the result of a host in the range
+type SweepResult struct {
+	*Statistics
+	// Alive is true if the host answered ping, or is resolved in the neighbor table if
+	// NeighborCache is set
+	Alive bool
+	// HardwareAddr is the link layer address in the neighbor table of host which didn't
+	// answer ping
+	HardwareAddr net.HardwareAddr
+	// Names are the names of alive host by reverse dns
+	Names []string
+}
+
+// Run pings all hosts in the range until the context is done or all of them are pinged,
+// and returns the results in the order of addresses.
+func (s *Sweeper) Run(ctx context.Context) ([]*SweepResult, error) {
+	hosts, err := sweepHosts(s.CIDR)
+	if err != nil {
+		return nil, err
+	}
+	if s.Count == 0 {
+		s.Count = 1
+	}
+	concurrency := s.Concurrency
+	if concurrency == 0 {
+		concurrency = defaultSweepConcurrency
+	}
+
+	m := &MultiPinger{
+		Pinger:      s.Pinger,
+		Targets:     hosts,
+		Rate:        s.Rate,
+		Concurrency: concurrency,
+	}
+	stats, err := m.Run(ctx)
+	results := make([]*SweepResult, 0, len(stats))
+	for _, st := range stats {
+		results = append(results, &SweepResult{Statistics: st, Alive: st.PacketsRecv > 0})
+	}
+	if err != nil || ctx.Err() != nil {
+		return results, err
+	}
+
+	if s.NeighborCache {
+		if err := s.checkNeighbors(ctx, results); err != nil {
+			return results, err
+		}
+	}
+	if s.ResolveNames {
+		s.resolveNames(ctx, results, concurrency)
+	}
+	return results, nil
+}
+
+// sweepHosts returns the host addresses in cidr
+func sweepHosts(cidr string) ([]string, error) {
+	ip, ipNet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return nil, err
+	}
+	ones, bits := ipNet.Mask.Size()
+	if bits-ones > 16 {
+		return nil, fmt.Errorf("too many hosts in %s, at most %d hosts can be swept", cidr, maxSweepHosts)
+	}
+
+	start := ip.Mask(ipNet.Mask)
+	if ip.To4() != nil {
+		start = start.To4()
+	}
+	n := 1 << (bits - ones)
+	first, last := 0, n-1
+	if ip.To4() != nil && bits-ones >= 2 {
+		// skip the network and broadcast addresses, /31 and /32 have none of them, RFC 3021
+		first, last = 1, n-2
+	}
+
+	hosts := make([]string, 0, last-first+1)
+	for i := first; i <= last; i++ {
+		host := make(net.IP, len(start))
+		copy(host, start)
+		// add i to the address, it's at most 16 bits
+		carry := i
+		for j := len(host) - 1; j >= 0 && carry > 0; j-- {
+			sum := int(host[j]) + carry&0xff
+			host[j] = byte(sum)
+			carry = carry>>8 + sum>>8
+		}
+		hosts = append(hosts, host.String())
+	}
+	return hosts, nil
+}
+
+// checkNeighbors marks the on-link hosts which are resolved in the neighbor table as alive.
+// It only reads the table, the resolution is triggered by kernel sending the probes, and it
+// waits until the resolving entries settle.
+func (s *Sweeper) checkNeighbors(ctx context.Context, results []*SweepResult) error {
+	pending := make(map[string]*SweepResult)
+	for _, r := range results {
+		ip := net.ParseIP(r.Target)
+		if r.Alive || ip == nil {
+			continue
+		}
+		if route, err := utils.LookupRoute(ip, nil); err == nil && route.Gateway == nil {
+			pending[ip.String()] = r
+		}
+	}
+
+	deadline := time.Now().Add(neighborWaitTime)
+	for len(pending) > 0 {
+		neighbors, err := utils.Neighbors()
+		if err != nil {
+			return err
+		}
+		resolving := false
+		for i := range neighbors {
+			neigh := &neighbors[i]
+			r, ok := pending[neigh.IP.String()]
+			if !ok {
+				continue
+			}
+			if neigh.Resolved() {
+				r.Alive = true
+				r.HardwareAddr = neigh.HardwareAddr
+				delete(pending, neigh.IP.String())
+			} else if neigh.Resolving() {
+				resolving = true
+			}
+		}
+		if !resolving || time.Now().After(deadline) {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(neighborPollInterval):
+		}
+	}
+	return nil
+}
+
+// resolveNames looks up the names of alive hosts, the failure of lookup is ignored
+func (s *Sweeper) resolveNames(ctx context.Context, results []*SweepResult, concurrency int) {
+	var g errgroup.Group
+	g.SetLimit(concurrency)
+	for _, r := range results {
+		r := r
+		if !r.Alive {
+			continue
+		}
+		g.Go(func() error {
+			if names, err := net.DefaultResolver.LookupAddr(ctx, r.Target); err == nil {
+				r.Names = names
+			}
+			return nil
+		})
+	}
+	_ = g.Wait()
+}
@@ -0,0 +1,64 @@
+package ping
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/joyme123/gnt/utils"
+)
+
+// runTCP sends tcp syn probes instead of icmp echo requests. Both syn-ack and rst are
+// replies, which mean the target host is alive.
+func (p *Pinger) runTCP(ctx context.Context) (*Statistics, error) {
+	err := p.Send(ctx, nil)
+	p.probing.Wait()
+	return p.Statistics(), err
+}
+
+// sendTCPProbe sends a tcp syn probe and waits for its reply in background
+func (p *Pinger) sendTCPProbe(ctx context.Context) {
+	seq := p.sequence
+	p.setSendMetrics(time.Now(), nil)
+	if p.Flood {
+		_, _ = p.log.Writer().Write([]byte("."))
+	}
+
+	p.probing.Add(1)
+	go func() {
+		defer p.probing.Done()
+
+		state, sentAt, err := utils.TCPHalfOpen(ctx, p.resolvedTargetAddr.IP, 0, p.Port, uint8(p.TTL), p.WaitTime, &p.SocketOptions)
+		p.processTCPReply(seq, sentAt, state, err)
+	}()
+}
+
+// processTCPReply measures the rtt from sentAt, which excludes the time of creating socket
+// and scheduling the goroutine.
+func (p *Pinger) processTCPReply(seq int, sentAt time.Time, state utils.TCPProbeState, err error) {
+	addr := &net.TCPAddr{IP: p.resolvedTargetAddr.IP, Port: p.Port}
+	switch state {
+	case utils.TCPProbeTimeout:
+		if err != nil {
+			p.log.Printf("tcp probe seq=%d failed: %v\n", seq, err)
+		}
+		return
+	case utils.TCPProbeUnreachable:
+		p.mu.Lock()
+		p.errorPackets++
+		p.mu.Unlock()
+		p.log.Printf("From %s tcp_seq=%d %v\n", addr, seq, err)
+		return
+	}
+
+	p.mu.Lock()
+	if pr, ok := p.probes[seq]; ok && !sentAt.IsZero() {
+		pr.sentAt = sentAt
+	}
+	p.mu.Unlock()
+	reply := p.matchReply(seq)
+	if reply == nil || p.printFlood(reply) {
+		return
+	}
+	p.log.Printf("Reply from %s: tcp_seq=%d port=%s time=%s ms%s\n", addr, seq, state, formatMs(reply.rtt), reply.flag)
+}
@@ -0,0 +1,191 @@
+package ping
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+)
+
+const (
+	// timestampLen is the length of icmp timestamp body: id, seq and three timestamps
+	timestampLen = 16
+	// nonStandardTimestamp is the high-order bit set in timestamps which are not the
+	// milliseconds since midnight UT, RFC 792
+	nonStandardTimestamp = 1 << 31
+
+	day = 24 * time.Hour
+)
+
+// ICMPTimestamp is the body of icmp timestamp request and reply, RFC 792. The timestamps
+// are milliseconds since midnight UT.
+type ICMPTimestamp struct {
+	ID        int
+	Seq       int
+	Originate uint32
+	Receive   uint32
+	Transmit  uint32
+}
+
+// Len implements the Len method of icmp.MessageBody interface
+func (t *ICMPTimestamp) Len(proto int) int {
+	if t == nil {
+		return 0
+	}
+	return timestampLen
+}
+
+// Marshal implements the Marshal method of icmp.MessageBody interface
+func (t *ICMPTimestamp) Marshal(proto int) ([]byte, error) {
+	b := make([]byte, timestampLen)
+	binary.BigEndian.PutUint16(b[0:2], uint16(t.ID))
+	binary.BigEndian.PutUint16(b[2:4], uint16(t.Seq))
+	binary.BigEndian.PutUint32(b[4:8], t.Originate)
+	binary.BigEndian.PutUint32(b[8:12], t.Receive)
+	binary.BigEndian.PutUint32(b[12:16], t.Transmit)
+	return b, nil
+}
+
+// parseICMPTimestamp parses the body of icmp timestamp request and reply
+func parseICMPTimestamp(b []byte) (*ICMPTimestamp, error) {
+	if len(b) < timestampLen {
+		return nil, fmt.Errorf("truncated icmp timestamp, %d bytes", len(b))
+	}
+	return &ICMPTimestamp{
+		ID:        int(binary.BigEndian.Uint16(b[0:2])),
+		Seq:       int(binary.BigEndian.Uint16(b[2:4])),
+		Originate: binary.BigEndian.Uint32(b[4:8]),
+		Receive:   binary.BigEndian.Uint32(b[8:12]),
+		Transmit:  binary.BigEndian.Uint32(b[12:16]),
+	}, nil
+}
+
+// decodeMessageBody decodes the bodies which are not known by icmp package
+func decodeMessageBody(rm *icmp.Message) {
+	switch rm.Type {
+	case ipv4.ICMPTypeTimestamp, ipv4.ICMPTypeTimestampReply:
+		body, ok := rm.Body.(*icmp.RawBody)
+		if !ok {
+			return
+		}
+		if ts, err := parseICMPTimestamp(body.Data); err == nil {
+			rm.Body = ts
+		}
+	}
+}
+
+// TimestampEstimate is the estimation of remote clock from the timestamps of a reply
+type TimestampEstimate struct {
+	// Offset is the remote clock minus the local clock, assuming the path is symmetric
+	Offset time.Duration
+	// Forward is the one-way delay from local host to target, including clock offset
+	Forward time.Duration
+	// Backward is the one-way delay from target to local host, including clock offset
+	Backward time.Duration
+	// Processing is the time between target received the request and sent the reply
+	Processing time.Duration
+}
+
+// EstimateClock estimates the remote clock from the timestamps of reply and the local
+// time when the reply is received. It returns false if target doesn't use the standard
+// timestamp.
+func (t *ICMPTimestamp) EstimateClock(receivedAt time.Time) (*TimestampEstimate, bool) {
+	if t.Receive&nonStandardTimestamp != 0 || t.Transmit&nonStandardTimestamp != 0 {
+		return nil, false
+	}
+	originate := time.Duration(t.Originate) * time.Millisecond
+	receive := time.Duration(t.Receive) * time.Millisecond
+	transmit := time.Duration(t.Transmit) * time.Millisecond
+	// the local time is truncated to milliseconds like the timestamps of target
+	local := sinceMidnight(receivedAt).Truncate(time.Millisecond)
+
+	e := &TimestampEstimate{
+		Forward:    clockDiff(receive, originate),
+		Backward:   clockDiff(local, transmit),
+		Processing: clockDiff(transmit, receive),
+	}
+	e.Offset = (e.Forward - e.Backward) / 2
+	return e, true
+}
+
+// sinceMidnight returns the time since midnight UT
+func sinceMidnight(t time.Time) time.Duration {
+	t = t.UTC()
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	return t.Sub(midnight)
+}
+
+// clockDiff returns a - b of the times since midnight, the difference is wrapped into
+// half a day around zero in case that midnight passed between them.
+func clockDiff(a, b time.Duration) time.Duration {
+	d := (a - b) % day
+	switch {
+	case d > day/2:
+		d -= day
+	case d <= -day/2:
+		d += day
+	}
+	return d
+}
+
+// timestampRequest builds the icmp timestamp request, the originate timestamp is the
+// time when the request is sent.
+func (p *Pinger) timestampRequest(seq int, sentAt time.Time) ([]byte, error) {
+	wm := icmp.Message{
+		Type: ipv4.ICMPTypeTimestamp,
+		Code: 0,
+		Body: &ICMPTimestamp{
+			ID:        p.id,
+			Seq:       seq,
+			Originate: uint32(sinceMidnight(sentAt) / time.Millisecond),
+		},
+	}
+	return wm.Marshal(nil)
+}
+
+func (p *Pinger) processTimestampReply(pkt *Packet) {
+	receivedAt := time.Now()
+	ts, ok := pkt.Message.Body.(*ICMPTimestamp)
+	if !ok {
+		p.debugLogger.V(4).Info("malformed timestamp reply", "message", pkt.Message)
+		return
+	}
+	if !p.matchID(p.id, ts.ID) {
+		return
+	}
+
+	reply := p.matchReply(ts.Seq)
+	if reply == nil {
+		return
+	}
+	estimate, standard := ts.EstimateClock(receivedAt)
+	if standard && reply.answered {
+		p.mu.Lock()
+		p.clockOffsets = append(p.clockOffsets, estimate.Offset)
+		p.mu.Unlock()
+	}
+	if p.OnReceiveTimestampReply != nil {
+		p.OnReceiveTimestampReply(reply.event(ts.Seq, pkt))
+	}
+
+	if p.printFlood(reply) {
+		return
+	}
+	p.log.Printf("%d bytes from %s: icmp_seq=%d ttl=%d time=%s ms%s\n", pkt.Bytes, pkt.Addr, ts.Seq, pkt.TTL, formatMs(reply.rtt), reply.flag)
+	if !standard {
+		p.log.Printf("\toriginate=%d receive=%#x transmit=%#x (non-standard timestamp)\n", ts.Originate, ts.Receive, ts.Transmit)
+		return
+	}
+	p.log.Printf("\toriginate=%d receive=%d transmit=%d offset=%s ms forward/backward=%s/%s ms\n",
+		ts.Originate, ts.Receive, ts.Transmit, formatOffset(estimate.Offset), formatMs(estimate.Forward), formatMs(estimate.Backward))
+}
+
+// formatOffset formats the clock offset in milliseconds with its sign
+func formatOffset(d time.Duration) string {
+	if d < 0 {
+		return "-" + formatMs(-d)
+	}
+	return "+" + formatMs(d)
+}
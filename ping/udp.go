@@ -0,0 +1,254 @@
+package ping
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+	"golang.org/x/sync/errgroup"
+)
+
+// runUDP sends udp probes instead of icmp echo requests. Both the reply from target
+// and icmp port unreachable mean the target host is alive. In privileged mode, icmp
+// errors are received by an icmp connection, so the codes can be classified, otherwise
+// only port unreachable and the hard errors reported by udp socket can be known.
+func (p *Pinger) runUDP(ctx context.Context) (*Statistics, error) {
+	var cancel context.CancelFunc
+	ctx, cancel = context.WithCancel(ctx)
+	defer cancel()
+
+	// udp probes are sent from the same address as icmp connection listens on, otherwise
+	// icmp errors may be sent to an address which icmp connection doesn't receive.
+	src, err := p.getAddrByInterface()
+	if err != nil {
+		return nil, err
+	}
+	p.udpSourceAddr = &net.UDPAddr{IP: net.ParseIP(src)}
+
+	var g errgroup.Group
+	if !p.Unprivileged {
+		c, err := p.listen()
+		if err != nil {
+			return nil, err
+		}
+		defer c.Close()
+		p.listeningICMP = true
+		g.Go(func() error {
+			return p.Receive(ctx, c)
+		})
+	}
+
+	err = p.Send(ctx, nil)
+	p.probing.Wait()
+	cancel()
+	if rerr := g.Wait(); err == nil {
+		err = rerr
+	}
+	return p.Statistics(), err
+}
+
+// sendUDPProbe sends a udp probe from a new socket, the source port identifies the
+// probe in icmp error messages. The reply is waited in background.
+func (p *Pinger) sendUDPProbe(ctx context.Context) error {
+	network := "udp4"
+	if p.ipProtocolVersion == 6 {
+		network = "udp6"
+	}
+	dialer := net.Dialer{
+		LocalAddr: p.udpSourceAddr,
+		Control: func(network, address string, c syscall.RawConn) error {
+			return p.SocketOptions.Apply(c, p.ipProtocolVersion == 6)
+		},
+	}
+	dc, err := dialer.DialContext(ctx, network, (&net.UDPAddr{
+		IP:   p.resolvedTargetAddr.IP,
+		Port: p.Port,
+	}).String())
+	if err != nil {
+		return err
+	}
+	conn := dc.(*net.UDPConn)
+	if err := p.setUDPTTL(conn); err != nil {
+		conn.Close()
+		return err
+	}
+
+	seq := p.sequence
+	sentAt := time.Now()
+	data := p.UDPData
+	if len(data) == 0 {
+		data = p.payload(sentAt)
+	}
+	srcPort := conn.LocalAddr().(*net.UDPAddr).Port
+	p.mu.Lock()
+	p.udpProbes[srcPort] = seq
+	p.mu.Unlock()
+
+	p.setSendMetrics(sentAt, data)
+	if p.Flood {
+		_, _ = p.log.Writer().Write([]byte("."))
+	}
+	if _, err := conn.Write(data); err != nil {
+		p.log.Printf("udp probe seq=%d failed: %v\n", seq, err)
+		p.removeUDPProbe(srcPort)
+		conn.Close()
+		return nil
+	}
+
+	p.probing.Add(1)
+	go func() {
+		defer p.probing.Done()
+		defer conn.Close()
+
+		if p.waitUDPReply(ctx, conn, seq, sentAt) {
+			p.removeUDPProbe(srcPort)
+		}
+	}()
+	return nil
+}
+
+// waitUDPReply waits the reply of udp probe. It returns false if the probe should be
+// kept for the icmp connection to handle the icmp error.
+func (p *Pinger) waitUDPReply(ctx context.Context, conn *net.UDPConn, seq int, sentAt time.Time) bool {
+	stop := unblockReadOnDone(ctx, conn)
+	defer stop()
+
+	if err := conn.SetReadDeadline(sentAt.Add(p.WaitTime)); err != nil {
+		return true
+	}
+	buf := make([]byte, 65536)
+	n, err := conn.Read(buf)
+	if err != nil {
+		if errors.Is(err, os.ErrDeadlineExceeded) || ctx.Err() != nil {
+			return true
+		}
+		// icmp errors are reported as the errors of connected udp socket, e.g. port
+		// unreachable as connection refused, they are handled by icmp connection if
+		// there is one.
+		if p.listeningICMP {
+			return false
+		}
+		var errno syscall.Errno
+		switch {
+		case errors.Is(err, syscall.ECONNREFUSED):
+			p.processUDPPortUnreachable(seq, conn.RemoteAddr())
+		case errors.As(err, &errno):
+			p.mu.Lock()
+			p.errorPackets++
+			p.mu.Unlock()
+			p.log.Printf("From %s udp_seq=%d %v\n", conn.RemoteAddr(), seq, errno)
+		}
+		return true
+	}
+	p.processUDPReply(seq, conn.RemoteAddr(), buf[:n])
+	return true
+}
+
+func (p *Pinger) processUDPReply(seq int, addr net.Addr, data []byte) {
+	reply := p.matchReply(seq)
+	if reply == nil {
+		return
+	}
+
+	var corruption string
+	if len(p.UDPExpect) > 0 {
+		if !bytes.Contains(data, p.UDPExpect) {
+			corruption = fmt.Sprintf("unexpected reply %q", data)
+		}
+	} else if len(p.UDPData) > 0 {
+		corruption = verifyPayload(p.UDPData, data)
+	} else {
+		expected := reply.probe.payload
+		if expected == nil {
+			expected = p.payload(reply.probe.sentAt)
+		}
+		corruption = verifyPayload(expected, data)
+	}
+	if corruption != "" {
+		p.mu.Lock()
+		p.corruptedPackets++
+		p.mu.Unlock()
+	}
+
+	if p.printFlood(reply) {
+		return
+	}
+	p.log.Printf("%d bytes from %s: udp_seq=%d time=%s ms%s\n", len(data), addr, seq, formatMs(reply.rtt), reply.flag)
+	if corruption != "" {
+		p.log.Println(corruption)
+	}
+}
+
+// processUDPPortUnreachable handles port unreachable of udp probe, which is a reply
+// from target host.
+func (p *Pinger) processUDPPortUnreachable(seq int, addr net.Addr) {
+	reply := p.matchReply(seq)
+	if reply == nil || p.printFlood(reply) {
+		return
+	}
+	p.log.Printf("From %s udp_seq=%d Port Unreachable time=%s ms%s\n", addr, seq, formatMs(reply.rtt), reply.flag)
+}
+
+// processUDPError handles icmp error received by icmp connection. The probe is found by
+// the source port of quoted udp header.
+func (p *Pinger) processUDPError(pkt *Packet, icmpErr *ICMPError, data []byte) {
+	quoted := ParseQuotedPacket(data)
+	if quoted == nil || quoted.Protocol != udpProtocol || len(quoted.Payload) < 2 ||
+		!quoted.Dst.Equal(p.resolvedTargetAddr.IP) {
+		return
+	}
+
+	srcPort := int(binary.BigEndian.Uint16(quoted.Payload[0:2]))
+	p.mu.Lock()
+	seq, ok := p.udpProbes[srcPort]
+	if !icmpErr.Advisory() {
+		// the probe may still be answered after advisory errors
+		delete(p.udpProbes, srcPort)
+	}
+	p.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	if p.isPortUnreachable(pkt.Message) {
+		p.processUDPPortUnreachable(seq, pkt.Addr)
+		return
+	}
+
+	icmpErr.Seq = seq
+	p.addICMPError(icmpErr)
+	p.log.Printf("From %s udp_seq=%d %s\n", icmpErr.Addr, seq, icmpErr.Name)
+}
+
+func (p *Pinger) removeUDPProbe(srcPort int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	delete(p.udpProbes, srcPort)
+}
+
+func (p *Pinger) setUDPTTL(conn *net.UDPConn) error {
+	if p.TTL <= 0 {
+		return nil
+	}
+	if p.ipProtocolVersion == 4 {
+		return ipv4.NewConn(conn).SetTTL(p.TTL)
+	}
+	return ipv6.NewConn(conn).SetHopLimit(p.TTL)
+}
+
+func (p *Pinger) isPortUnreachable(rm *icmp.Message) bool {
+	if p.ipProtocolVersion == 4 {
+		return rm.Type == ipv4.ICMPTypeDestinationUnreachable && rm.Code == 3
+	}
+	return rm.Type == ipv6.ICMPTypeDestinationUnreachable && rm.Code == 4
+}
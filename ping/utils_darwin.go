@@ -4,9 +4,12 @@
 package ping
 
 import (
+	"syscall"
+
 	"golang.org/x/net/icmp"
 	"golang.org/x/net/ipv4"
 	"golang.org/x/net/ipv6"
+	"golang.org/x/sys/unix"
 )
 
 func (p *Pinger) parseMessage(proto int, buf []byte) (*icmp.Message, error) {
@@ -37,3 +40,22 @@ func (p *Pinger) setTTL(c *icmp.PacketConn) error {
 
 	return nil
 }
+
+func (p *Pinger) initConn(c *icmp.PacketConn) error {
+	return nil
+}
+
+func (p *Pinger) readErrQueue(c *icmp.PacketConn) *Packet {
+	return nil
+}
+
+// setIPOptions sets the ipv4 options of packets sent by the socket
+func setIPOptions(c syscall.RawConn, opts []byte) error {
+	var err error
+	if cerr := c.Control(func(fd uintptr) {
+		err = unix.SetsockoptString(int(fd), unix.IPPROTO_IP, unix.IP_OPTIONS, string(opts))
+	}); cerr != nil {
+		return cerr
+	}
+	return err
+}
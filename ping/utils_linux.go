@@ -4,9 +4,15 @@
 package ping
 
 import (
+	"encoding/binary"
+	"net"
+	"syscall"
+	"unsafe"
+
 	"golang.org/x/net/icmp"
 	"golang.org/x/net/ipv4"
 	"golang.org/x/net/ipv6"
+	"golang.org/x/sys/unix"
 )
 
 func (p *Pinger) parseMessage(proto int, buf []byte) (*icmp.Message, error) {
@@ -14,11 +20,6 @@ func (p *Pinger) parseMessage(proto int, buf []byte) (*icmp.Message, error) {
 }
 
 func (p *Pinger) matchID(id int, replyID int) bool {
-	if p.Unprivileged {
-		return true
-	}
-
-	// only matmatchIch id with privileged mode
 	return p.id == replyID
 }
 
@@ -42,3 +43,188 @@ func (p *Pinger) setTTL(c *icmp.PacketConn) error {
 
 	return nil
 }
+
+// initConn prepares the unprivileged connection. Linux replaces the id of echo request
+// with the local port of datagram icmp socket, and delivers icmp errors only to the
+// error queue of socket if IP_RECVERR is enabled.
+func (p *Pinger) initConn(c *icmp.PacketConn) error {
+	if !p.Unprivileged {
+		return nil
+	}
+
+	if addr, ok := c.LocalAddr().(*net.UDPAddr); ok {
+		p.id = addr.Port
+	}
+
+	rc, err := p.rawConn(c)
+	if err != nil {
+		return err
+	}
+	level, opt := unix.IPPROTO_IP, unix.IP_RECVERR
+	if p.ipProtocolVersion == 6 {
+		level, opt = unix.IPPROTO_IPV6, unix.IPV6_RECVERR
+	}
+	var serr error
+	if err := rc.Control(func(fd uintptr) {
+		serr = unix.SetsockoptInt(int(fd), level, opt, 1)
+	}); err != nil {
+		return err
+	}
+	return serr
+}
+
+// readErrQueue reads an icmp error from the error queue of unprivileged connection without
+// blocking, and rebuilds the message as raw socket receives it: the original datagram
+// is quoted with an ip header. It returns nil if there is no icmp error.
+func (p *Pinger) readErrQueue(c *icmp.PacketConn) *Packet {
+	if !p.Unprivileged {
+		return nil
+	}
+	rc, err := p.rawConn(c)
+	if err != nil {
+		return nil
+	}
+
+	buf := make([]byte, 1500)
+	oob := make([]byte, 512)
+	var n, oobn int
+	var dst unix.Sockaddr
+	var rerr error
+	if err := rc.Read(func(fd uintptr) bool {
+		n, oobn, _, dst, rerr = unix.Recvmsg(int(fd), buf, oob, unix.MSG_ERRQUEUE|unix.MSG_DONTWAIT)
+		return true
+	}); err != nil || rerr != nil {
+		return nil
+	}
+
+	cmsgs, err := unix.ParseSocketControlMessage(oob[:oobn])
+	if err != nil {
+		return nil
+	}
+	for _, cmsg := range cmsgs {
+		if p.ipProtocolVersion == 4 && cmsg.Header.Level == unix.IPPROTO_IP && cmsg.Header.Type == unix.IP_RECVERR ||
+			p.ipProtocolVersion == 6 && cmsg.Header.Level == unix.IPPROTO_IPV6 && cmsg.Header.Type == unix.IPV6_RECVERR {
+			pkt := p.errQueuePacket(cmsg.Data, c.LocalAddr(), dst, buf[:n])
+			if pkt != nil {
+				p.debugLogger.V(4).Info("receive packet from error queue", "ip", pkt.Addr, "type", pkt.Message.Type, "code", pkt.Message.Code)
+			}
+			return pkt
+		}
+	}
+	return nil
+}
+
+// errQueuePacket builds the icmp error from sock_extended_err, which is followed by the
+// address of the host sending the icmp error.
+func (p *Pinger) errQueuePacket(b []byte, local net.Addr, dst unix.Sockaddr, data []byte) *Packet {
+	const eeLen = int(unsafe.Sizeof(unix.SockExtendedErr{}))
+	if len(b) < eeLen {
+		return nil
+	}
+	ee := (*unix.SockExtendedErr)(unsafe.Pointer(&b[0]))
+	origin, typ, code, info := ee.Origin, int(ee.Type), int(ee.Code), int(ee.Info)
+
+	var src net.IP
+	if ip, ok := local.(*net.UDPAddr); ok {
+		src = ip.IP
+	}
+	var offender, target net.IP
+	var quoted []byte
+	if p.ipProtocolVersion == 4 {
+		if origin != unix.SO_EE_ORIGIN_ICMP || len(b) < eeLen+8 {
+			return nil
+		}
+		offender = net.IP(b[eeLen+4 : eeLen+8])
+		if sa, ok := dst.(*unix.SockaddrInet4); ok {
+			target = net.IP(sa.Addr[:])
+		}
+		hdr := &ipv4.Header{
+			Version:  ipv4.Version,
+			Len:      ipv4.HeaderLen,
+			TotalLen: ipv4.HeaderLen + len(data),
+			TTL:      p.TTL,
+			Protocol: 1,
+			Src:      src.To4(),
+			Dst:      target.To4(),
+		}
+		h, err := hdr.Marshal()
+		if err != nil {
+			return nil
+		}
+		quoted = append(h, data...)
+	} else {
+		if origin != unix.SO_EE_ORIGIN_ICMP6 || len(b) < eeLen+24 {
+			return nil
+		}
+		offender = net.IP(b[eeLen+8 : eeLen+24])
+		if sa, ok := dst.(*unix.SockaddrInet6); ok {
+			target = net.IP(sa.Addr[:])
+		}
+		h := make([]byte, ipv6.HeaderLen)
+		h[0] = ipv6.Version << 4
+		binary.BigEndian.PutUint16(h[4:6], uint16(len(data)))
+		h[6] = 58
+		h[7] = byte(p.TTL)
+		copy(h[8:24], src.To16())
+		copy(h[24:40], target.To16())
+		quoted = append(h, data...)
+	}
+
+	rm := &icmp.Message{Code: code}
+	if p.ipProtocolVersion == 4 {
+		rm.Type = ipv4.ICMPType(typ)
+		switch rm.Type {
+		case ipv4.ICMPTypeDestinationUnreachable:
+			rm.Body = &icmp.DstUnreach{Data: quoted}
+		case ipv4.ICMPTypeTimeExceeded:
+			rm.Body = &icmp.TimeExceeded{Data: quoted}
+		case ipv4.ICMPTypeParameterProblem:
+			rm.Body = &icmp.ParamProb{Pointer: uintptr(info), Data: quoted}
+		default:
+			// the unused field of source quench precedes the original datagram
+			rm.Body = &icmp.RawBody{Data: append(make([]byte, 4), quoted...)}
+		}
+	} else {
+		rm.Type = ipv6.ICMPType(typ)
+		switch rm.Type {
+		case ipv6.ICMPTypeDestinationUnreachable:
+			rm.Body = &icmp.DstUnreach{Data: quoted}
+		case ipv6.ICMPTypeTimeExceeded:
+			rm.Body = &icmp.TimeExceeded{Data: quoted}
+		case ipv6.ICMPTypePacketTooBig:
+			rm.Body = &icmp.PacketTooBig{MTU: info, Data: quoted}
+		case ipv6.ICMPTypeParameterProblem:
+			rm.Body = &icmp.ParamProb{Pointer: uintptr(info), Data: quoted}
+		default:
+			rm.Body = &icmp.RawBody{Data: append(make([]byte, 4), quoted...)}
+		}
+	}
+
+	raw, err := rm.Marshal(nil)
+	if err != nil {
+		return nil
+	}
+	if rm.Type == ipv4.ICMPTypeDestinationUnreachable && rm.Code == fragmentationNeeded {
+		// the next hop mtu is in the unused field of destination unreachable
+		binary.BigEndian.PutUint16(raw[6:8], uint16(info))
+	}
+
+	return &Packet{
+		Message: rm,
+		Bytes:   len(raw),
+		Addr:    &net.UDPAddr{IP: offender},
+		TTL:     -1,
+		Raw:     raw,
+	}
+}
+
+// setIPOptions sets the ipv4 options of packets sent by the socket
+func setIPOptions(c syscall.RawConn, opts []byte) error {
+	var err error
+	if cerr := c.Control(func(fd uintptr) {
+		err = unix.SetsockoptString(int(fd), unix.IPPROTO_IP, unix.IP_OPTIONS, string(opts))
+	}); cerr != nil {
+		return cerr
+	}
+	return err
+}
@@ -4,6 +4,9 @@
 package ping
 
 import (
+	"fmt"
+	"syscall"
+
 	"golang.org/x/net/icmp"
 	"golang.org/x/net/ipv4"
 	"golang.org/x/net/ipv6"
@@ -38,3 +41,15 @@ func (p *Pinger) setTTL(c *icmp.PacketConn) error {
 
 	return nil
 }
+
+func (p *Pinger) initConn(c *icmp.PacketConn) error {
+	return nil
+}
+
+func (p *Pinger) readErrQueue(c *icmp.PacketConn) *Packet {
+	return nil
+}
+
+func setIPOptions(c syscall.RawConn, opts []byte) error {
+	return fmt.Errorf("ip options are not supported")
+}
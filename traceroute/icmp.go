@@ -1 +1,97 @@
 package traceroute
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"syscall"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// ICMPConn sends icmp echo requests as probes. The connection is shared with the pinger
+// receiving the replies, because the icmp errors of unprivileged connection are only
+// delivered to the socket sending the probe, and linux replaces the echo id with the
+// local port of socket.
+type ICMPConn struct {
+	conn *icmp.PacketConn
+	// mu serializes setting ttl and writing, the ttl is an option of the shared socket
+	mu sync.Mutex
+}
+
+var _ Conn = &ICMPConn{}
+var _ ParisConn = &ICMPConn{}
+
+func NewICMPConn(c *icmp.PacketConn) *ICMPConn {
+	return &ICMPConn{
+		conn: c,
+	}
+}
+
+// SendProbe sends an echo request, srcPort is the echo id and dstPort is the sequence
+// which encodes the hop and probe index.
+func (r *ICMPConn) SendProbe(ctx context.Context, addr *net.IPAddr, srcPort, dstPort int, ttl uint8, data []byte) error {
+	return r.send(addr, srcPort, dstPort, ttl, data)
+}
+
+// SendParisProbe sends an echo request whose sequence is dstPort plus the identity, the
+// payload keeps the checksum constant.
+func (r *ICMPConn) SendParisProbe(ctx context.Context, addr *net.IPAddr, srcPort, dstPort int, ttl uint8, identity int) error {
+	seq := dstPort + identity
+	return r.send(addr, srcPort, seq, ttl, icmpParisPayload(seq))
+}
+
+func (r *ICMPConn) send(addr *net.IPAddr, id, seq int, ttl uint8, data []byte) error {
+	ipv6Probe := addr.IP.To4() == nil
+	var typ icmp.Type = ipv4.ICMPTypeEcho
+	if ipv6Probe {
+		typ = ipv6.ICMPTypeEchoRequest
+	}
+	wm := icmp.Message{
+		Type: typ,
+		Code: 0,
+		Body: &icmp.Echo{
+			ID:   id & 0xffff,
+			Seq:  seq & 0xffff,
+			Data: data,
+		},
+	}
+	// the checksum of icmpv6 is calculated by kernel
+	b, err := wm.Marshal(nil)
+	if err != nil {
+		return err
+	}
+
+	var dst net.Addr = addr
+	if _, ok := r.conn.LocalAddr().(*net.UDPAddr); ok {
+		dst = &net.UDPAddr{IP: addr.IP, Zone: addr.Zone}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if ipv6Probe {
+		err = r.conn.IPv6PacketConn().SetHopLimit(int(ttl))
+	} else {
+		err = r.conn.IPv4PacketConn().SetTTL(int(ttl))
+	}
+	if err != nil {
+		return err
+	}
+	// the icmp errors of previous probes are queued on the socket, and the pending one is
+	// reported by sending instead of this probe, so it's sent again
+	for i := 0; i < 3; i++ {
+		if _, err = r.conn.WriteTo(b, dst); !isICMPError(err) {
+			break
+		}
+	}
+	return err
+}
+
+// isICMPError returns true if err is converted from icmp errors
+func isICMPError(err error) bool {
+	return errors.Is(err, syscall.EHOSTUNREACH) || errors.Is(err, syscall.ENETUNREACH) ||
+		errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.EPROTO)
+}
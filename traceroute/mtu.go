@@ -0,0 +1,119 @@
+package traceroute
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"golang.org/x/net/icmp"
+
+	"github.com/joyme123/gnt/ping"
+	"github.com/joyme123/gnt/utils"
+)
+
+// mtuPlateaus are the common mtu values in RFC 1191, used when a hop doesn't advertise
+// the mtu of next hop
+var mtuPlateaus = []int{65535, 32000, 17914, 8166, 4352, 2002, 1492, 1006, 508, 296, 68}
+
+// runMTU traces the path like tracepath: icmp echo requests with DF set are sent with
+// increasing ttl, and the size of probes is reduced when a hop advertises a smaller mtu,
+// so the mtu is known at each hop along the path.
+func (r *TraceRouter) runMTU(ctx context.Context) error {
+	network := "ip"
+	if r.IPv4 {
+		network = "ip4"
+	} else if r.IPv6 {
+		network = "ip6"
+	}
+	opts := r.socketOptions
+	opts.PMTUDisc = utils.PMTUDiscProbe
+	pinger := ping.Pinger{
+		Network:       network,
+		TargetAddr:    r.DstAddr,
+		Unprivileged:  r.Unprivileged,
+		WaitTime:      r.WaitTime,
+		SocketOptions: opts,
+	}
+	pinger.SetDebugLogger(r.debugLogger)
+	c, err := pinger.Listen(ctx)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	queries := r.Nqueries
+	if queries <= 0 {
+		queries = 1
+	}
+	mtu := pinger.RouteMTU()
+	fmt.Fprintf(r.out, "%2d?: [LOCALHOST] pmtu %d\n", r.FirstTTL, mtu)
+	for ttl := int(r.FirstTTL); ttl <= int(r.MaxTTL); ttl++ {
+		res, err := r.probeMTUHop(ctx, &pinger, c, ttl, queries, &mtu)
+		if err != nil || ctx.Err() != nil {
+			return err
+		}
+		if res.Reached || res.Unreachable {
+			fmt.Fprintf(r.out, "     Resume: pmtu %d hops %d\n", mtu, ttl)
+			return nil
+		}
+	}
+	fmt.Fprintf(r.out, "     Too many hops: pmtu %d\n", mtu)
+	return nil
+}
+
+// probeMTUHop probes the hop at ttl, and prints the hop and the new mtu if it's reduced
+func (r *TraceRouter) probeMTUHop(ctx context.Context, pinger *ping.Pinger, c *icmp.PacketConn, ttl, queries int, mtu *int) (*ping.MTUProbe, error) {
+	for i := 0; i < queries; {
+		res, err := pinger.ProbeMTU(ctx, c, ttl, *mtu)
+		if err != nil || ctx.Err() != nil {
+			return &ping.MTUProbe{}, err
+		}
+
+		switch {
+		case res.TooBig:
+			hop := res.Addr
+			if hop == "" {
+				hop = "[LOCALHOST]"
+			}
+			next := res.MTU
+			if next <= 0 || next >= *mtu {
+				next = nextMTUPlateau(*mtu)
+			}
+			fmt.Fprintf(r.out, "%2d:  %-30s %8.3fms pmtu %d\n", ttl, hop, ms(res.RTT), next)
+			if next >= *mtu {
+				// the mtu can't be reduced any more, so the answer counts as a query
+				i++
+				continue
+			}
+			*mtu = next
+		case !res.Answered():
+			i++
+			if i == queries {
+				fmt.Fprintf(r.out, "%2d:  no reply\n", ttl)
+			}
+		default:
+			suffix := ""
+			if res.Reached {
+				suffix = " reached"
+			} else if res.Unreachable {
+				suffix = " unreachable"
+			}
+			fmt.Fprintf(r.out, "%2d:  %-30s %8.3fms%s\n", ttl, res.Addr, ms(res.RTT), suffix)
+			return res, nil
+		}
+	}
+	return &ping.MTUProbe{}, nil
+}
+
+func nextMTUPlateau(mtu int) int {
+	for _, plateau := range mtuPlateaus {
+		if plateau < mtu {
+			return plateau
+		}
+	}
+	return mtuPlateaus[len(mtuPlateaus)-1]
+}
+
+func ms(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
@@ -0,0 +1,240 @@
+package traceroute
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"strings"
+	"time"
+)
+
+// maxFlows is the max number of flows probed at each hop
+const maxFlows = 512
+
+// flowProbe is a probe of flow to hop ttl
+type flowProbe struct {
+	ttl  uint8
+	flow int
+}
+
+// SendMultipath discovers the load balanced paths to target with a simplified multipath
+// detection algorithm (MDA), https://www.paris-traceroute.net/. The flows of udp probes
+// differ in the destination port, and the flows of tcp probes in the source port so that the
+// service probed is kept. The stopping rule of MDA is applied to each hop instead of each
+// interface: a hop is probed with more flows until enough flows are sent to find all of its
+// interfaces with the confidence level, and with at least the flows of previous hop so that
+// the next hops of each interface are found. There is no node control, which chooses the
+// flows through a particular interface, so the number of flows only grows along the path.
+// The hop graph is printed as the next hops of each interface are known, branching points
+// are the interfaces with several next hops.
+func (r *TraceRouter) SendMultipath(ctx context.Context) error {
+	if r.method == "icmp" {
+		return errors.New("multipath traceroute is not supported by icmp method")
+	}
+	if r.Confidence <= 0 || r.Confidence >= 1 {
+		return fmt.Errorf("confidence level %v must be between 0 and 1", r.Confidence)
+	}
+	addr := r.dstAddr
+	if addr == nil {
+		var err error
+		if addr, err = r.resolvAddr(); err != nil {
+			return err
+		}
+	}
+
+	// hops are the probes of each flow at each hop
+	hops := make(map[uint8]map[int]*probe)
+	printed := int(r.FirstTTL)
+	defer func() {
+		// the probes are still answered if it's interrupted
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		for ttl := printed; ttl < int(r.FirstTTL)+len(hops); ttl++ {
+			r.printHop(uint8(ttl), hops[uint8(ttl)], hops[uint8(ttl+1)])
+		}
+	}()
+
+	for ttl := int(r.FirstTTL); ttl <= int(r.MaxTTL); ttl++ {
+		hops[uint8(ttl)] = make(map[int]*probe)
+		for flows := 0; ; {
+			n := mdaProbes(len(interfaces(hops[uint8(ttl)])), 1-r.Confidence)
+			// the flows of previous hop are all probed, so each interface of previous hop
+			// has its next hops probed by all of its flows
+			if prev := len(hops[uint8(ttl-1)]); n < prev {
+				n = prev
+			}
+			if n > maxFlows {
+				n = maxFlows
+			}
+			if flows >= n {
+				break
+			}
+
+			var batch []flowProbe
+			for f := flows; f < n; f++ {
+				batch = append(batch, flowProbe{ttl: uint8(ttl), flow: f})
+				// the flow is also probed at previous hop to find the link
+				if prev, ok := hops[uint8(ttl-1)]; ok && prev[f] == nil {
+					batch = append(batch, flowProbe{ttl: uint8(ttl - 1), flow: f})
+				}
+			}
+			probes, err := r.sendFlows(ctx, addr, batch)
+			for _, p := range probes {
+				hops[p.ttl][p.flow] = p
+			}
+			if err != nil {
+				return err
+			}
+			if ctx.Err() != nil {
+				return nil
+			}
+			flows = n
+		}
+
+		// the previous hop doesn't change any more
+		for ; printed < ttl; printed++ {
+			r.printHop(uint8(printed), hops[uint8(printed)], hops[uint8(printed+1)])
+		}
+		if reachedAll(hops[uint8(ttl)]) {
+			return nil
+		}
+	}
+	return nil
+}
+
+// sendFlows sends the probes, keeping up to Squeries of them in flight, and waits until
+// all of them are answered or timed out.
+func (r *TraceRouter) sendFlows(ctx context.Context, addr *net.IPAddr, batch []flowProbe) ([]*probe, error) {
+	sendWait := r.sendWait()
+
+	r.mu.Lock()
+	start := len(r.probes)
+	r.mu.Unlock()
+
+	sent := 0
+	var lastSent time.Time
+	for {
+		now := time.Now()
+		r.mu.Lock()
+		wait := r.expire(now, r.WaitTime)
+		canSend := sent < len(batch) && r.inflight < r.Squeries
+		done := sent == len(batch) && r.inflight == 0
+		probes := r.probes[start:]
+		r.mu.Unlock()
+		if done {
+			return probes, nil
+		}
+
+		if canSend {
+			if d := lastSent.Add(sendWait).Sub(now); d > 0 {
+				if d < wait {
+					wait = d
+				}
+			} else {
+				lastSent = now
+				fp := batch[sent]
+				sent++
+				if err := r.sendProbe(ctx, addr, fp.ttl, fp.flow, fp.flow); err != nil {
+					return probes, err
+				}
+				continue
+			}
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return probes, nil
+		case err := <-r.sendErr:
+			timer.Stop()
+			return probes, err
+		case <-r.updated:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+// mdaProbes returns the number of flows to send to a hop with k interfaces found, so that
+// the probability of missing the (k+1)th interface is at most alpha if it exists and the
+// flows are spread evenly.
+func mdaProbes(k int, alpha float64) int {
+	if k < 1 {
+		k = 1
+	}
+	return int(math.Ceil(math.Log(alpha/float64(k+1)) / math.Log(float64(k)/float64(k+1))))
+}
+
+// interfaces returns the addresses answering the probes of a hop, in the order of flows
+func interfaces(probes map[int]*probe) []string {
+	return addrsOf(probes, func(int) bool { return true })
+}
+
+// addrsOf returns the distinct addresses answering the probes of flows, in the order of flows
+func addrsOf(probes map[int]*probe, match func(flow int) bool) []string {
+	var addrs []string
+	seen := make(map[string]bool)
+	for f := 0; f < maxFlows; f++ {
+		p := probes[f]
+		if p == nil || p.addr == "" || seen[p.addr] || !match(f) {
+			continue
+		}
+		seen[p.addr] = true
+		addrs = append(addrs, p.addr)
+	}
+	return addrs
+}
+
+// reachedAll returns true if all the answered probes of a hop reached the target
+func reachedAll(probes map[int]*probe) bool {
+	answered := false
+	for _, p := range probes {
+		if p.addr == "" {
+			continue
+		}
+		if !p.reached {
+			return false
+		}
+		answered = true
+	}
+	return answered
+}
+
+// printHop prints the interfaces of a hop with the next hops of flows through them
+func (r *TraceRouter) printHop(ttl uint8, probes, next map[int]*probe) {
+	addrs := interfaces(probes)
+	if len(addrs) == 0 {
+		fmt.Fprintf(r.out, "%2d  *\n", ttl)
+		return
+	}
+	for i, addr := range addrs {
+		if i == 0 {
+			fmt.Fprintf(r.out, "%2d  ", ttl)
+		} else {
+			fmt.Fprint(r.out, "    ")
+		}
+		rtt := time.Duration(math.MaxInt64)
+		flows := 0
+		for _, p := range probes {
+			if p.addr != addr {
+				continue
+			}
+			flows++
+			if p.rtt < rtt {
+				rtt = p.rtt
+			}
+		}
+		fmt.Fprintf(r.out, "%s  %.3f ms  %d flow(s)", addr, float64(rtt.Microseconds())/1000, flows)
+
+		nextAddrs := addrsOf(next, func(f int) bool {
+			return probes[f] != nil && probes[f].addr == addr
+		})
+		if len(nextAddrs) > 0 {
+			fmt.Fprintf(r.out, "  -> %s", strings.Join(nextAddrs, ", "))
+		}
+		fmt.Fprintln(r.out)
+	}
+}
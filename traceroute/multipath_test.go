@@ -0,0 +1,54 @@
+package traceroute
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func Test_mdaProbes(t *testing.T) {
+	// the number of probes at 95% confidence level published with MDA
+	tests := []struct {
+		k    int
+		want int
+	}{
+		{k: 0, want: 6},
+		{k: 1, want: 6},
+		{k: 2, want: 11},
+		{k: 3, want: 16},
+		{k: 4, want: 21},
+		{k: 5, want: 27},
+	}
+	for _, tt := range tests {
+		if got := mdaProbes(tt.k, 0.05); got != tt.want {
+			t.Errorf("mdaProbes(%d, 0.05) = %d, want %d", tt.k, got, tt.want)
+		}
+	}
+}
+
+func TestTraceRouter_printHop(t *testing.T) {
+	out := &bytes.Buffer{}
+	r := newTestTraceRouter(3, out)
+	// hop 2 load balances the flows over 2 interfaces, the probe of flow 3 is lost
+	hop := map[int]*probe{
+		0: {ttl: 2, flow: 0, addr: "10.0.0.1", rtt: 2 * time.Millisecond},
+		1: {ttl: 2, flow: 1, addr: "10.0.0.1", rtt: time.Millisecond},
+		2: {ttl: 2, flow: 2, addr: "10.0.0.11", rtt: 3 * time.Millisecond},
+		3: {ttl: 2, flow: 3},
+	}
+	next := map[int]*probe{
+		0: {ttl: 3, flow: 0, addr: "10.0.1.2"},
+		1: {ttl: 3, flow: 1, addr: "10.0.2.2"},
+		2: {ttl: 3, flow: 2, addr: "10.0.1.2"},
+		3: {ttl: 3, flow: 3, addr: "10.0.2.2"},
+	}
+	r.printHop(2, hop, next)
+	r.printHop(3, map[int]*probe{0: {ttl: 3, flow: 0}}, nil)
+
+	want := " 2  10.0.0.1  1.000 ms  2 flow(s)  -> 10.0.1.2, 10.0.2.2\n" +
+		"    10.0.0.11  3.000 ms  1 flow(s)  -> 10.0.1.2\n" +
+		" 3  *\n"
+	if out.String() != want {
+		t.Errorf("printed\n%s\nwant\n%s", out.String(), want)
+	}
+}
@@ -1,5 +1,11 @@
 package traceroute
 
+import (
+	"time"
+
+	"github.com/joyme123/gnt/utils"
+)
+
 type Options struct {
 	IPv4 bool
 	IPv6 bool
@@ -25,13 +31,28 @@ type Options struct {
 	// For ICMP tracing, specifies the initial icmp sequence value (incremented by each probe too).
 	// For TCP specifies just the (constant) destination port to connect.
 	Port int
-	// Set the time (in seconds) to wait for a response to a probe (default 5.0 sec).
-	WaitTime int
+	// Set the time to wait for a response to a probe (default 5.0 sec).
+	WaitTime time.Duration
 	// Minimal time interval between probes (default 0).
-	// If the value is more than 10, then it specifies a number in milliseconds,
-	// else it is a number of seconds (float point values allowed too).
+	// If the value is more than 10 seconds, then it specifies a number in milliseconds,
+	// e.g. 20 seconds means 20 milliseconds, else it is a number of seconds.
 	// Useful when some routers use rate-limit for icmp messages.
-	SendWait int
-	// Unprivileged mode
+	SendWait time.Duration
+	// Unprivileged mode, only for icmp method and mtu discovery. The icmp errors of udp and
+	// tcp probes are received by raw socket.
 	Unprivileged bool
+	// Discover the mtu along the path like tracepath, icmp echo with DF set is used for probes
+	MTU bool
+	// Paris keeps the five-tuple of probes constant like paris traceroute, so the per-flow
+	// load balancers forward all of them along the same path
+	Paris bool
+	// Multipath discovers all the load balanced paths to target with a simplified multipath
+	// detection algorithm applying the stopping rule per hop, the flows are told apart by
+	// the destination port of paris udp probes, or the source port of paris tcp probes
+	Multipath bool
+	// Confidence is the confidence level of multipath detection that all the next hops of
+	// a hop are found. Defaults to 0.95.
+	Confidence float64
+	// SocketOptions are the tos, firewall mark, bound device and path mtu discovery of probe sockets
+	utils.SocketOptions
 }
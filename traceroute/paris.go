@@ -0,0 +1,89 @@
+package traceroute
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+)
+
+// ParisConn sends probes of paris traceroute. The five-tuple of probes is constant, so the
+// per-flow load balancers forward all of them along the same path, and the identity of
+// probe is encoded in the fields ignored by load balancers:
+//
+//   - udp: the checksum, which is the identity plus 1, by choosing the payload
+//   - icmp: the sequence, which is dstPort plus the identity, the payload compensates the
+//     checksum so that it's constant
+//   - tcp: the sequence number
+type ParisConn interface {
+	SendParisProbe(ctx context.Context, addr *net.IPAddr, srcPort, dstPort int, ttl uint8, identity int) error
+}
+
+// sum returns the one's complement sum of b in 16 bits words
+func sum(b []byte) uint32 {
+	var s uint32
+	for i := 0; i+1 < len(b); i += 2 {
+		s += uint32(binary.BigEndian.Uint16(b[i:]))
+	}
+	if len(b)%2 == 1 {
+		s += uint32(b[len(b)-1]) << 8
+	}
+	return s
+}
+
+// fold folds the sum into 16 bits
+func fold(s uint32) uint16 {
+	for s > 0xffff {
+		s = (s >> 16) + (s & 0xffff)
+	}
+	return uint16(s)
+}
+
+// pseudoHeaderSum returns the sum of pseudo header of tcp and udp checksum
+func pseudoHeaderSum(src, dst net.IP, protocol uint8, length int) uint32 {
+	if src4, dst4 := src.To4(), dst.To4(); src4 != nil && dst4 != nil {
+		return sum(src4) + sum(dst4) + uint32(protocol) + uint32(length)
+	}
+	return sum(src.To16()) + sum(dst.To16()) + uint32(protocol) + uint32(length>>16) + uint32(length&0xffff)
+}
+
+// checksum returns the internet checksum of data with the sum of pseudo header
+func checksum(pseudo uint32, data []byte) uint16 {
+	return ^fold(pseudo + sum(data))
+}
+
+// udpParisPayload returns the 2 bytes payload which makes the checksum of udp probe be
+// identity plus 1. The checksum is never 0 or 0xffff, which are the same in one's complement.
+func udpParisPayload(src, dst net.IP, srcPort, dstPort, identity int) []byte {
+	header := make([]byte, 8)
+	binary.BigEndian.PutUint16(header[0:2], uint16(srcPort))
+	binary.BigEndian.PutUint16(header[2:4], uint16(dstPort))
+	binary.BigEndian.PutUint16(header[4:6], 10)
+	s := fold(pseudoHeaderSum(src, dst, 17, 10) + sum(header))
+	// the sum of payload is the complement of checksum minus the sum of others
+	want := ^uint16(identity + 1)
+	payload := make([]byte, 2)
+	binary.BigEndian.PutUint16(payload, fold(uint32(want)+uint32(^s)))
+	return payload
+}
+
+// icmpParisPayload returns the 2 bytes payload which compensates the sequence, the sum of
+// them is always 0xffff, so the checksum of icmp probe is constant.
+func icmpParisPayload(seq int) []byte {
+	payload := make([]byte, 2)
+	binary.BigEndian.PutUint16(payload, ^uint16(seq))
+	return payload
+}
+
+// parseTCPAnswer returns the identity of paris tcp probe answered by the syn-ack or rst seg
+// to a port matched by isSrcPort, which acknowledges the sequence number of probe.
+func parseTCPAnswer(seg []byte, isSrcPort func(port int) bool) (int, bool) {
+	if len(seg) < 20 || !isSrcPort(int(binary.BigEndian.Uint16(seg[2:4]))) {
+		return 0, false
+	}
+	flags := seg[13]
+	// ack must be set with syn or rst
+	if flags&0x10 == 0 || flags&(0x02|0x04) == 0 {
+		return 0, false
+	}
+	return int(binary.BigEndian.Uint32(seg[8:12]) - 1), true
+}
@@ -0,0 +1,226 @@
+package traceroute
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// serializeProbe serializes the ip datagram of probe with the checksums calculated by gopacket
+func serializeProbe(t *testing.T, src, dst net.IP, l4 gopacket.SerializableLayer, payload []byte) []byte {
+	var ip gopacket.NetworkLayer
+	var ipLayer gopacket.SerializableLayer
+	proto := layers.IPProtocolUDP
+	if _, ok := l4.(*layers.TCP); ok {
+		proto = layers.IPProtocolTCP
+	}
+	if src.To4() != nil {
+		ip4 := &layers.IPv4{Version: 4, IHL: 5, TTL: 1, Protocol: proto, SrcIP: src, DstIP: dst}
+		ip, ipLayer = ip4, ip4
+	} else {
+		ip6 := &layers.IPv6{Version: 6, HopLimit: 1, NextHeader: proto, SrcIP: src, DstIP: dst}
+		ip, ipLayer = ip6, ip6
+	}
+	switch l := l4.(type) {
+	case *layers.UDP:
+		_ = l.SetNetworkLayerForChecksum(ip)
+	case *layers.TCP:
+		_ = l.SetNetworkLayerForChecksum(ip)
+	}
+
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	if err := gopacket.SerializeLayers(buf, opts, ipLayer, l4, gopacket.Payload(payload)); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func Test_udpParisPayload(t *testing.T) {
+	tests := []struct {
+		name     string
+		src      string
+		dst      string
+		identity int
+	}{
+		{name: "ipv4 first probe", src: "10.0.0.1", dst: "10.0.1.2", identity: 0},
+		{name: "ipv4", src: "192.168.1.10", dst: "8.8.8.8", identity: 1234},
+		{name: "ipv4 max identity", src: "10.0.0.1", dst: "10.0.1.2", identity: 65533},
+		{name: "ipv6 first probe", src: "fd00::1", dst: "fd01::2", identity: 0},
+		{name: "ipv6", src: "2001:db8::1", dst: "2001:db8:1::53", identity: 4321},
+		{name: "ipv6 max identity", src: "fd00::1", dst: "fd01::2", identity: 65533},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src, dst := net.ParseIP(tt.src), net.ParseIP(tt.dst)
+			payload := udpParisPayload(src, dst, 40000, 33434, tt.identity)
+			pkt := serializeProbe(t, src, dst, &layers.UDP{SrcPort: 40000, DstPort: 33434}, payload)
+
+			udp := pkt[len(pkt)-10:]
+			if got := int(binary.BigEndian.Uint16(udp[6:8])); got != tt.identity+1 {
+				t.Errorf("checksum of probe is %d, want %d", got, tt.identity+1)
+			}
+		})
+	}
+}
+
+func Test_icmpParisPayload(t *testing.T) {
+	src, dst := net.ParseIP("fd00::1"), net.ParseIP("fd01::2")
+	tests := []struct {
+		name string
+		typ  icmp.Type
+		psh  []byte
+	}{
+		{name: "icmp", typ: ipv4.ICMPTypeEcho},
+		{name: "icmpv6", typ: ipv6.ICMPTypeEchoRequest, psh: icmp.IPv6PseudoHeader(src, dst)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var want []byte
+			for _, seq := range []int{1, 2, 255, 256, 33434, 65535} {
+				msg := icmp.Message{
+					Type: tt.typ,
+					Body: &icmp.Echo{ID: 0x8123, Seq: seq, Data: icmpParisPayload(seq)},
+				}
+				b, err := msg.Marshal(tt.psh)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if want == nil {
+					want = b[2:4]
+				} else if string(b[2:4]) != string(want) {
+					t.Errorf("checksum of seq %d is %x, want %x", seq, b[2:4], want)
+				}
+			}
+		})
+	}
+}
+
+func TestTraceRouter_processReceivePacket(t *testing.T) {
+	hop := &net.IPAddr{IP: net.ParseIP("10.0.0.254")}
+	tests := []struct {
+		name      string
+		method    string
+		multipath bool
+		src       string
+		dst       string
+		identity  int
+		l4        func(identity int) gopacket.SerializableLayer
+		payload   func(src, dst net.IP, identity int) []byte
+	}{
+		{
+			name:     "paris udp",
+			method:   "default",
+			src:      "10.0.0.1",
+			dst:      "10.0.1.2",
+			identity: 5,
+			l4:       func(int) gopacket.SerializableLayer { return &layers.UDP{SrcPort: 40000, DstPort: 33434} },
+			payload: func(src, dst net.IP, identity int) []byte {
+				return udpParisPayload(src, dst, 40000, 33434, identity)
+			},
+		},
+		{
+			name:     "paris udp over ipv6",
+			method:   "udp",
+			src:      "fd00::1",
+			dst:      "fd01::2",
+			identity: 7,
+			l4:       func(int) gopacket.SerializableLayer { return &layers.UDP{SrcPort: 40000, DstPort: 33434} },
+			payload: func(src, dst net.IP, identity int) []byte {
+				return udpParisPayload(src, dst, 40000, 33434, identity)
+			},
+		},
+		{
+			name:     "paris tcp",
+			method:   "tcp",
+			src:      "10.0.0.1",
+			dst:      "10.0.1.2",
+			identity: 4,
+			l4: func(identity int) gopacket.SerializableLayer {
+				return &layers.TCP{SrcPort: 40000, DstPort: 33434, Seq: uint32(identity), SYN: true, Window: 0xffff, DataOffset: 5}
+			},
+			payload: func(net.IP, net.IP, int) []byte { return nil },
+		},
+		{
+			// the flow 3 is sent from the source port 39936+3, 39936 is 40000 aligned to 512 flows
+			name:      "multipath tcp",
+			method:    "tcp",
+			multipath: true,
+			src:       "fd00::1",
+			dst:       "fd01::2",
+			identity:  6,
+			l4: func(identity int) gopacket.SerializableLayer {
+				return &layers.TCP{SrcPort: 39939, DstPort: 33434, Seq: uint32(identity), SYN: true, Window: 0xffff, DataOffset: 5}
+			},
+			payload: func(net.IP, net.IP, int) []byte { return nil },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src, dst := net.ParseIP(tt.src), net.ParseIP(tt.dst)
+			r := &TraceRouter{
+				Paris:       true,
+				Multipath:   tt.multipath,
+				Nqueries:    3,
+				FirstTTL:    1,
+				method:      tt.method,
+				startPort:   33434,
+				echoID:      40000,
+				dstAddr:     &net.IPAddr{IP: dst},
+				debugLogger: logr.Discard(),
+			}
+			for i := 0; i < 9; i++ {
+				r.probes = append(r.probes, &probe{ttl: uint8(1 + i/3), index: i % 3})
+			}
+			r.inflight = len(r.probes)
+
+			data := serializeProbe(t, src, dst, tt.l4(tt.identity), tt.payload(src, dst, tt.identity))
+			r.processReceivePacket(data, hop, false)
+
+			for i, p := range r.probes {
+				if answered := i == tt.identity; p.done != answered || (answered && p.addr != hop.IP.String()) {
+					t.Errorf("probe %d is answered %v by %q, want %v", i, p.done, p.addr, answered)
+				}
+			}
+		})
+	}
+}
+
+func Test_parseTCPAnswer(t *testing.T) {
+	segment := func(dstPort uint16, ack uint32, flags byte) []byte {
+		seg := make([]byte, 20)
+		binary.BigEndian.PutUint16(seg[0:2], 80)
+		binary.BigEndian.PutUint16(seg[2:4], dstPort)
+		binary.BigEndian.PutUint32(seg[8:12], ack)
+		seg[12], seg[13] = 5<<4, flags
+		return seg
+	}
+	tests := []struct {
+		name     string
+		seg      []byte
+		identity int
+		ok       bool
+	}{
+		{name: "syn-ack", seg: segment(40000, 8, 0x12), identity: 7, ok: true},
+		{name: "rst-ack", seg: segment(40000, 1, 0x14), identity: 0, ok: true},
+		{name: "other port", seg: segment(40001, 8, 0x12)},
+		{name: "syn without ack", seg: segment(40000, 8, 0x02)},
+		{name: "ack only", seg: segment(40000, 8, 0x10)},
+		{name: "truncated", seg: segment(40000, 8, 0x12)[:12]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			identity, ok := parseTCPAnswer(tt.seg, func(port int) bool { return port == 40000 })
+			if ok != tt.ok || (ok && identity != tt.identity) {
+				t.Errorf("parseTCPAnswer() = %d, %v, want %d, %v", identity, ok, tt.identity, tt.ok)
+			}
+		})
+	}
+}
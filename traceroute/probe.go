@@ -0,0 +1,239 @@
+package traceroute
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/joyme123/gnt/utils"
+)
+
+// probe is a probe sent to a hop
+type probe struct {
+	ttl uint8
+	// index is the index of probe in its hop
+	index int
+	// flow is the flow of multipath probe, its ports are returned by flowPorts
+	flow   int
+	sentAt time.Time
+	// addr is the address answering the probe, it's empty if the probe timed out
+	addr string
+	rtt  time.Duration
+	done bool
+	// reached is true if the answer means the probe reached the target
+	reached bool
+}
+
+// Send sends Nqueries probes per hop, keeping up to Squeries probes in flight across the
+// hops. It returns when the probes of all hops up to the target or MaxTTL are answered or
+// timed out, and the hops are printed in order as soon as all of their probes are done.
+func (r *TraceRouter) Send(ctx context.Context) error {
+	addr := r.dstAddr
+	if addr == nil {
+		var err error
+		if addr, err = r.resolvAddr(); err != nil {
+			return err
+		}
+	}
+
+	sendWait := r.sendWait()
+
+	var lastSent time.Time
+	for {
+		now := time.Now()
+		r.mu.Lock()
+		wait := r.expire(now, r.WaitTime)
+		r.print()
+		finished := r.finished()
+		canSend := !finished && r.inflight < r.Squeries && r.nextTTL() <= r.lastTTL()
+		r.mu.Unlock()
+		if finished {
+			return nil
+		}
+
+		if canSend {
+			if d := lastSent.Add(sendWait).Sub(now); d > 0 {
+				if d < wait {
+					wait = d
+				}
+			} else {
+				lastSent = now
+				n := len(r.probes)
+				if err := r.sendProbe(ctx, addr, uint8(r.nextTTL()), n%r.Nqueries, 0); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil
+		case err := <-r.sendErr:
+			timer.Stop()
+			return err
+		case <-r.updated:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+// sendWait returns the minimal interval between probes, SendWait more than 10 seconds
+// specifies a number in milliseconds.
+func (r *TraceRouter) sendWait() time.Duration {
+	if r.SendWait > 10*time.Second {
+		return r.SendWait / 1000
+	}
+	return r.SendWait
+}
+
+// sendProbe sends a probe to hop ttl, its identity is encoded in the destination port of
+// udp and tcp probes, or the sequence of icmp probes. The fields of paris probes are
+// described in ParisConn.
+func (r *TraceRouter) sendProbe(ctx context.Context, addr *net.IPAddr, ttl uint8, index, flow int) error {
+	r.mu.Lock()
+	n := len(r.probes)
+	r.probes = append(r.probes, &probe{
+		ttl:    ttl,
+		index:  index,
+		flow:   flow,
+		sentAt: time.Now(),
+	})
+	r.inflight++
+	r.mu.Unlock()
+
+	if r.Paris {
+		pc, ok := r.conn.(ParisConn)
+		if !ok {
+			return fmt.Errorf("paris traceroute is not supported by %s method", r.method)
+		}
+		srcPort, dstPort := r.flowPorts(flow)
+		return pc.SendParisProbe(ctx, addr, srcPort, dstPort, ttl, n)
+	}
+	if tp, ok := r.conn.(TCPProber); ok {
+		// the tcp probe waits for the answer of target, so it doesn't block the others
+		go func() {
+			state, err := tp.ProbeTCP(ctx, addr, r.id(), r.startPort+n, ttl, r.WaitTime)
+			switch {
+			case state == utils.TCPProbeOpen || state == utils.TCPProbeClosed:
+				r.receiveProbe(n, addr.IP.String(), true)
+			case err != nil && ctx.Err() == nil:
+				select {
+				case r.sendErr <- err:
+				default:
+				}
+			}
+		}()
+		return nil
+	}
+	return r.conn.SendProbe(ctx, addr, r.id(), r.startPort+n, ttl, []byte{0x00, uint8(index)})
+}
+
+// receiveProbe records the answer of the n-th probe, reached is true if the answer means the
+// probe reached the target.
+func (r *TraceRouter) receiveProbe(n int, addr string, reached bool) {
+	r.mu.Lock()
+	if n < 0 || n >= len(r.probes) || r.probes[n].done {
+		// late answers of timed out probes are dropped
+		r.mu.Unlock()
+		return
+	}
+	p := r.probes[n]
+	p.done = true
+	p.addr = addr
+	p.rtt = time.Since(p.sentAt)
+	p.reached = reached
+	r.inflight--
+	if reached && (r.destTTL == 0 || p.ttl < r.destTTL) {
+		r.destTTL = p.ttl
+	}
+	r.mu.Unlock()
+
+	select {
+	case r.updated <- struct{}{}:
+	default:
+	}
+}
+
+// nextTTL returns the ttl of next probe, it's an int because it may exceed 255
+func (r *TraceRouter) nextTTL() int {
+	return int(r.FirstTTL) + len(r.probes)/r.Nqueries
+}
+
+// lastTTL returns the last hop to probe
+func (r *TraceRouter) lastTTL() int {
+	if r.destTTL != 0 {
+		return int(r.destTTL)
+	}
+	return int(r.MaxTTL)
+}
+
+// expire marks the probes waiting longer than waitTime as timed out, and returns the time
+// until the next probe times out.
+func (r *TraceRouter) expire(now time.Time, waitTime time.Duration) time.Duration {
+	wait := waitTime
+	for _, p := range r.probes[r.printed:] {
+		if p.done {
+			continue
+		}
+		if d := p.sentAt.Add(waitTime).Sub(now); d > 0 {
+			if d < wait {
+				wait = d
+			}
+			continue
+		}
+		p.done = true
+		r.inflight--
+	}
+	return wait
+}
+
+// finished returns true if the probes of all hops up to the last one are done
+func (r *TraceRouter) finished() bool {
+	if r.nextTTL() <= r.lastTTL() {
+		return false
+	}
+	for _, p := range r.probes[r.printed:] {
+		if int(p.ttl) <= r.lastTTL() && !p.done {
+			return false
+		}
+	}
+	return true
+}
+
+// print prints the done probes in order, the probes beyond the target are never printed
+func (r *TraceRouter) print() {
+	for ; r.printed < len(r.probes); r.printed++ {
+		p := r.probes[r.printed]
+		if !p.done || int(p.ttl) > r.lastTTL() {
+			return
+		}
+		if p.index == 0 {
+			fmt.Fprintf(r.out, "%2d ", p.ttl)
+		}
+		if p.addr == "" {
+			fmt.Fprint(r.out, " *")
+		} else {
+			if p.index == 0 || r.probes[r.printed-1].addr != p.addr {
+				fmt.Fprintf(r.out, " %s", p.addr)
+			}
+			fmt.Fprintf(r.out, "  %.3f ms", float64(p.rtt.Microseconds())/1000)
+		}
+		if p.index == r.Nqueries-1 {
+			fmt.Fprintln(r.out)
+		}
+	}
+}
+
+// flush terminates the line of hop being printed when traceroute is interrupted
+func (r *TraceRouter) flush() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.printed%r.Nqueries != 0 {
+		fmt.Fprintln(r.out)
+	}
+}
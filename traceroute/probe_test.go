@@ -0,0 +1,184 @@
+package traceroute
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+)
+
+// answeringConn answers each probe as soon as it's sent, the hop at ttl is 10.0.0.ttl and
+// the target is reached at reachTTL.
+type answeringConn struct {
+	r        *TraceRouter
+	reachTTL uint8
+	sent     []string
+}
+
+func (c *answeringConn) SendProbe(ctx context.Context, addr *net.IPAddr, srcPort, dstPort int, ttl uint8, data []byte) error {
+	c.sent = append(c.sent, fmt.Sprintf("%d/%d/%d", dstPort, ttl, data[1]))
+	if ttl >= c.reachTTL {
+		c.r.receiveProbe(dstPort-c.r.startPort, addr.IP.String(), true)
+	} else {
+		c.r.receiveProbe(dstPort-c.r.startPort, fmt.Sprintf("10.0.0.%d", ttl), false)
+	}
+	return nil
+}
+
+func newTestTraceRouter(nqueries int, out *bytes.Buffer) *TraceRouter {
+	return &TraceRouter{
+		FirstTTL:    1,
+		MaxTTL:      30,
+		Nqueries:    nqueries,
+		Squeries:    16,
+		WaitTime:    5 * time.Second,
+		startPort:   33434,
+		echoID:      40000,
+		dstAddr:     &net.IPAddr{IP: net.ParseIP("10.0.1.2")},
+		updated:     make(chan struct{}, 1),
+		sendErr:     make(chan error, 1),
+		out:         out,
+		debugLogger: logr.Discard(),
+	}
+}
+
+func TestTraceRouter_Send(t *testing.T) {
+	out := &bytes.Buffer{}
+	r := newTestTraceRouter(2, out)
+	conn := &answeringConn{r: r, reachTTL: 3}
+	r.conn = conn
+
+	if err := r.Send(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// the probes beyond the target are not sent once it's reached
+	wantSent := []string{"33434/1/0", "33435/1/1", "33436/2/0", "33437/2/1", "33438/3/0", "33439/3/1"}
+	if strings.Join(conn.sent, " ") != strings.Join(wantSent, " ") {
+		t.Errorf("sent probes %v, want %v", conn.sent, wantSent)
+	}
+	wantLines := []string{
+		`^ 1  10\.0\.0\.1  \d+\.\d{3} ms  \d+\.\d{3} ms$`,
+		`^ 2  10\.0\.0\.2  \d+\.\d{3} ms  \d+\.\d{3} ms$`,
+		`^ 3  10\.0\.1\.2  \d+\.\d{3} ms  \d+\.\d{3} ms$`,
+	}
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	if len(lines) != len(wantLines) {
+		t.Fatalf("printed %q, want %d hops", out.String(), len(wantLines))
+	}
+	for i, want := range wantLines {
+		if !regexp.MustCompile(want).MatchString(lines[i]) {
+			t.Errorf("hop %d is printed as %q, want %s", i+1, lines[i], want)
+		}
+	}
+}
+
+func TestTraceRouter_print(t *testing.T) {
+	const waitTime = 5 * time.Second
+	now := time.Now()
+
+	out := &bytes.Buffer{}
+	r := newTestTraceRouter(3, out)
+	r.MaxTTL = 4
+	// 4 hops are probed, the first probe of hop 2 is lost
+	for n := 0; n < 12; n++ {
+		sentAt := now
+		if n == 3 {
+			sentAt = now.Add(-waitTime)
+		}
+		r.probes = append(r.probes, &probe{ttl: uint8(1 + n/3), index: n % 3, sentAt: sentAt})
+	}
+	r.inflight = len(r.probes)
+	answer := func(n int, addr string, reached bool) {
+		r.receiveProbe(n, addr, reached)
+		r.probes[n].rtt = time.Millisecond
+	}
+	step := func() bool {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.expire(now, waitTime)
+		r.print()
+		return r.finished()
+	}
+
+	// hop 2 is answered before hop 1, and nothing is printed until hop 1 is done
+	answer(4, "10.0.0.2", false)
+	answer(5, "10.0.0.22", false)
+	answer(0, "10.0.0.1", false)
+	if step() || out.String() != " 1  10.0.0.1  1.000 ms" {
+		t.Fatalf("printed %q before hop 1 is done", out.String())
+	}
+
+	// the target replies at hop 3, so hop 4 is never printed
+	answer(1, "10.0.0.1", false)
+	answer(2, "10.0.0.1", false)
+	answer(6, "10.0.1.2", true)
+	answer(7, "10.0.1.2", true)
+	answer(10, "10.0.1.2", true)
+	if step() {
+		t.Fatal("finished before the last probe of target is done")
+	}
+	answer(8, "10.0.1.2", true)
+	if !step() {
+		t.Fatal("not finished after all the probes up to target are done")
+	}
+
+	want := " 1  10.0.0.1  1.000 ms  1.000 ms  1.000 ms\n" +
+		" 2  * 10.0.0.2  1.000 ms 10.0.0.22  1.000 ms\n" +
+		" 3  10.0.1.2  1.000 ms  1.000 ms  1.000 ms\n"
+	if out.String() != want {
+		t.Errorf("printed\n%s\nwant\n%s", out.String(), want)
+	}
+	if r.inflight != 2 {
+		t.Errorf("%d probes are in flight, want 2 probes of hop 4", r.inflight)
+	}
+}
+
+func TestTraceRouter_expire(t *testing.T) {
+	const waitTime = 5 * time.Second
+	now := time.Now()
+	r := newTestTraceRouter(3, &bytes.Buffer{})
+	r.probes = []*probe{
+		{ttl: 1, sentAt: now.Add(-6 * time.Second)},
+		{ttl: 1, index: 1, sentAt: now.Add(-2 * time.Second)},
+		{ttl: 1, index: 2, sentAt: now.Add(-4 * time.Second), done: true},
+	}
+	r.inflight = 2
+
+	if wait := r.expire(now, waitTime); wait != 3*time.Second {
+		t.Errorf("wait %v for the next timeout, want 3s", wait)
+	}
+	if !r.probes[0].done || r.probes[0].addr != "" || r.probes[1].done || r.inflight != 1 {
+		t.Errorf("only the first probe should time out, %d probes are in flight", r.inflight)
+	}
+	// the answer of timed out probe is dropped
+	r.receiveProbe(0, "10.0.0.1", false)
+	if r.probes[0].addr != "" || r.inflight != 1 {
+		t.Errorf("the late answer of timed out probe is recorded")
+	}
+}
+
+func TestTraceRouter_sendWait(t *testing.T) {
+	tests := []struct {
+		sendWait time.Duration
+		want     time.Duration
+	}{
+		{sendWait: 0, want: 0},
+		{sendWait: 200 * time.Millisecond, want: 200 * time.Millisecond},
+		{sendWait: 10 * time.Second, want: 10 * time.Second},
+		{sendWait: 20 * time.Second, want: 20 * time.Millisecond},
+		{sendWait: 10500 * time.Millisecond, want: 10500 * time.Microsecond},
+	}
+	for _, tt := range tests {
+		r := &TraceRouter{SendWait: tt.sendWait}
+		if got := r.sendWait(); got != tt.want {
+			t.Errorf("sendWait() of %v = %v, want %v", tt.sendWait, got, tt.want)
+		}
+	}
+}
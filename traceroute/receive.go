@@ -3,13 +3,13 @@ package traceroute
 import (
 	"context"
 	"encoding/binary"
-	"fmt"
 	"net"
 	"time"
 
 	"golang.org/x/net/icmp"
 	"golang.org/x/net/ipv4"
 	"golang.org/x/net/ipv6"
+	"golang.org/x/sync/errgroup"
 
 	"github.com/joyme123/gnt/ping"
 	"github.com/joyme123/gnt/utils"
@@ -24,148 +24,135 @@ func (r *TraceRouter) Receive(ctx context.Context, ch chan<- struct{}) error {
 	}
 
 	pinger := ping.Pinger{
-		Network:                         network,
-		Deadline:                        1,
-		TargetAddr:                      r.DstAddr,
-		Unprivileged:                    r.Unprivileged,
-		OnReceiveEchoReply:              r.onReceiveEchoReply,
-		OnReceiveTTLExceeded:            r.onReceiveTTLExceeded,
-		OnReceiveDestinationUnreachable: r.onReceiveDestinationUnreachable,
+		Network:      network,
+		Deadline:     time.Second,
+		TargetAddr:   r.dstAddr.String(),
+		Unprivileged: r.Unprivileged,
+		HandlePacket: r.handlePacket,
+	}
+	if r.method == "icmp" {
+		// the probes are sent on the connection of pinger
+		pinger.SocketOptions = r.socketOptions
 	}
 	pinger.SetDebugLogger(r.debugLogger)
 	c, err := pinger.Listen(ctx)
 	if err != nil {
 		return err
 	}
+	if r.method == "icmp" {
+		r.echoID = pinger.ID()
+		r.conn = NewICMPConn(c)
+	}
+
+	ac, ok := r.conn.(AnswerConn)
+	if !ok {
+		ch <- struct{}{}
+		r.debugLogger.V(4).Info("start receive packets")
+		return pinger.Receive(ctx, c)
+	}
 
+	if err := ac.ListenAnswers(r.dstAddr); err != nil {
+		return err
+	}
 	ch <- struct{}{}
-	r.debugLogger.V(4).Info("start receive packets")
-	return pinger.Receive(ctx, c)
+	r.debugLogger.V(4).Info("start receive packets and answers of target")
+	g, ctx := errgroup.WithContext(ctx)
+	g.Go(func() error {
+		return pinger.Receive(ctx, c)
+	})
+	g.Go(func() error {
+		return ac.ReceiveAnswers(ctx, r.isSrcPort, func(identity int) {
+			r.receiveProbe(identity, r.dstAddr.IP.String(), true)
+		})
+	})
+	return g.Wait()
 }
 
-func (r *TraceRouter) onReceiveEchoReply(rm *icmp.Message, n int, ip net.Addr, ttl int) {
-	msg, err := rm.Body.(*icmp.Echo).Marshal(0)
-	if err != nil {
+// handlePacket dispatches the packets received by pinger, which doesn't know the probes
+func (r *TraceRouter) handlePacket(pkt *ping.Packet) {
+	switch pkt.Message.Type {
+	case ipv4.ICMPTypeEchoReply, ipv6.ICMPTypeEchoReply:
+		r.onReceiveEchoReply(pkt)
+	case ipv4.ICMPTypeTimeExceeded, ipv6.ICMPTypeTimeExceeded:
+		r.onReceiveTTLExceeded(pkt)
+	case ipv4.ICMPTypeDestinationUnreachable, ipv6.ICMPTypeDestinationUnreachable:
+		r.onReceiveDestinationUnreachable(pkt)
+	}
+}
+
+// onReceiveEchoReply handles the reply of icmp probe from target
+func (r *TraceRouter) onReceiveEchoReply(pkt *ping.Packet) {
+	echo, ok := pkt.Message.Body.(*icmp.Echo)
+	if !ok || r.method != "icmp" || echo.ID != r.id() {
 		return
 	}
-	r.processReceivePacket(msg, ip)
+	addr := utils.IPAddrString(pkt.Addr)
+	if addr != r.dstAddr.IP.String() {
+		return
+	}
+	r.receiveProbe(echo.Seq-r.startPort, addr, true)
 }
 
-func (r *TraceRouter) onReceiveTTLExceeded(rm *icmp.Message, ip net.Addr) {
-	msg := rm.Body.(*icmp.TimeExceeded)
-	r.processReceivePacket(msg.Data, ip)
+func (r *TraceRouter) onReceiveTTLExceeded(pkt *ping.Packet) {
+	msg := pkt.Message.Body.(*icmp.TimeExceeded)
+	r.processReceivePacket(msg.Data, pkt.Addr, false)
 }
 
-func (r *TraceRouter) onReceiveDestinationUnreachable(rm *icmp.Message, ip net.Addr) {
-	msg := rm.Body.(*icmp.DstUnreach)
-	r.processReceivePacket(msg.Data, ip)
+func (r *TraceRouter) onReceiveDestinationUnreachable(pkt *ping.Packet) {
+	msg := pkt.Message.Body.(*icmp.DstUnreach)
+	// the probe is not going any further even if it's not the target answering
+	r.processReceivePacket(msg.Data, pkt.Addr, true)
 }
 
-func (r *TraceRouter) processReceivePacket(data []byte, ip net.Addr) {
-	// https://www.iana.org/assignments/protocol-numbers/protocol-numbers.xml
-	var receivedDstIP net.IP
+func (r *TraceRouter) processReceivePacket(data []byte, ip net.Addr, reached bool) {
 	// udp: src port, tcp: src port, icmp: id
 	var receivedSrcIdentity int
-	// udp/tcp: dst_port-start_dst_port, icmp: seq
+	// udp/tcp: dst_port-start_dst_port, icmp: seq-start_seq
+	// paris udp: checksum-1, paris tcp: sequence number
 	var receivedDstIdentity int
-	var layer4Data []byte
-	var receivedProtocol int
 
-	if r.IPv4 {
-		hdr, err := ipv4.ParseHeader(data[0:ipv4.HeaderLen])
-		if err != nil {
+	// the extension headers of ipv6 are skipped, so the protocol is the upper layer protocol
+	quoted := ping.ParseQuotedPacket(data)
+	if quoted == nil {
+		return
+	}
+	receivedDstIP, layer4Data := quoted.Dst, quoted.Payload
+	// https://www.iana.org/assignments/protocol-numbers/protocol-numbers.xml
+	icmpProtocol := 1
+	if receivedDstIP.To4() == nil {
+		icmpProtocol = 58
+	}
+
+	switch {
+	case len(layer4Data) < 8:
+		return
+	case r.Paris && quoted.Protocol == 17 && (r.method == "udp" || r.method == "default"):
+		if !r.Multipath && int(binary.BigEndian.Uint16(layer4Data[2:4])) != r.startPort {
 			return
 		}
-		receivedDstIP = hdr.Dst
-		receivedProtocol = hdr.Protocol
-		layer4Data = data[ipv4.HeaderLen:]
-	} else {
-		hdr, err := ipv6.ParseHeader(data[0:ipv6.HeaderLen])
-		if err != nil {
+		receivedSrcIdentity = int(binary.BigEndian.Uint16(layer4Data[0:2]))
+		receivedDstIdentity = int(binary.BigEndian.Uint16(layer4Data[6:8])) - 1
+	case r.Paris && quoted.Protocol == 6 && r.method == "tcp":
+		// the flows of multipath tcp probes differ in the source port
+		if int(binary.BigEndian.Uint16(layer4Data[2:4])) != r.startPort {
 			return
 		}
-		receivedDstIP = hdr.Dst
-		layer4Data = data[ipv6.HeaderLen:]
-		receivedProtocol = hdr.NextHeader
-	}
-
-	if receivedProtocol == 17 && (r.method == "udp" || r.method == "default") {
+		receivedSrcIdentity = int(binary.BigEndian.Uint16(layer4Data[0:2]))
+		receivedDstIdentity = int(binary.BigEndian.Uint32(layer4Data[4:8]))
+	case quoted.Protocol == 17 && (r.method == "udp" || r.method == "default"):
 		receivedSrcIdentity = int(binary.BigEndian.Uint16(layer4Data[0:2]))
 		receivedDstIdentity = int(binary.BigEndian.Uint16(layer4Data[2:4])) - r.startPort
-	} else if receivedProtocol == 6 && r.method == "tcp" {
+	case quoted.Protocol == 6 && r.method == "tcp":
 		receivedSrcIdentity = int(binary.BigEndian.Uint16(layer4Data[0:2]))
 		receivedDstIdentity = int(binary.BigEndian.Uint16(layer4Data[2:4])) - r.startPort
-	} else if receivedProtocol == 1 && r.method == "icmp" {
+	case quoted.Protocol == icmpProtocol && r.method == "icmp":
 		receivedSrcIdentity = int(binary.BigEndian.Uint16(layer4Data[4:6]))
-		receivedDstIdentity = int(binary.BigEndian.Uint16(layer4Data[6:8]))
+		receivedDstIdentity = int(binary.BigEndian.Uint16(layer4Data[6:8])) - r.startPort
 	}
 
-	if receivedSrcIdentity != r.id() || receivedDstIP.String() != r.DstAddr {
+	if !r.isSrcPort(receivedSrcIdentity) || !receivedDstIP.Equal(r.dstAddr.IP) {
 		return
 	}
 
-	r.updateStatistic((uint8(receivedDstIdentity))/3+1, receivedDstIdentity%3, utils.IPAddrString(ip))
-}
-
-func (r *TraceRouter) updateStatistic(ttl uint8, index int, ip string) {
-
-	// check timeout probe packets
-	if ip == "" {
-		for t, timestamps := range r.sendPacketsTimestamps {
-			addrs, ok := r.statistics[t]
-			if !ok || len(addrs) < 3 {
-				for i, timestamp := range timestamps {
-					if timestamp.Add(5 * time.Second).Before(time.Now()) {
-						// timeout
-						if r.statistics[t] == nil {
-							r.statistics[t] = make(map[int]*PacketInfo)
-						}
-						if r.statistics[t][i] == nil {
-							r.statistics[t][i] = &PacketInfo{
-								IP:  "*",
-								RTT: 0,
-							}
-						}
-					}
-				}
-			}
-		}
-	} else {
-		if r.statistics[ttl] == nil {
-			r.statistics[ttl] = make(map[int]*PacketInfo)
-		}
-		r.statistics[ttl][index] = &PacketInfo{
-			IP:  ip,
-			RTT: int(time.Now().Sub(r.sendPacketsTimestamps[ttl][index]).Milliseconds()),
-		}
-	}
-	r.printStatistic()
-}
-
-func (r *TraceRouter) printStatistic() {
-	addrs, ok := r.statistics[r.printTTL]
-	if !ok {
-		return
-	}
-
-	if addrs[r.printAddr] != nil {
-		if r.printAddr == 0 {
-			fmt.Printf("%d  ", r.printTTL)
-		}
-		for i := r.printAddr; addrs[i] != nil; i++ {
-			if addrs[i].IP == "*" {
-				fmt.Printf("%s  ", addrs[i].IP)
-			} else {
-				fmt.Printf("%s %dms ", addrs[i].IP, addrs[i].RTT)
-			}
-			r.printAddr++
-		}
-		if len(addrs) == 3 {
-			r.printTTL++
-			r.printAddr = 0
-			fmt.Println()
-		} else {
-			return
-		}
-	}
+	r.receiveProbe(receivedDstIdentity, utils.IPAddrString(ip), reached)
 }
@@ -9,10 +9,15 @@ import (
 	"golang.org/x/sys/unix"
 )
 
-func SetTTL(conn syscall.RawConn, ttl uint8) error {
+// SetTTL sets the ttl of ipv4 socket, or the unicast hop limit of ipv6 socket
+func SetTTL(conn syscall.RawConn, ttl uint8, ipv6 bool) error {
+	level, opt := unix.IPPROTO_IP, unix.IP_TTL
+	if ipv6 {
+		level, opt = unix.IPPROTO_IPV6, unix.IPV6_UNICAST_HOPS
+	}
 	var err error
 	if e := conn.Control(func(fd uintptr) {
-		err = unix.SetsockoptInt(int(fd), unix.IPPROTO_IP, unix.IP_TTL, int(ttl))
+		err = unix.SetsockoptInt(int(fd), level, opt, int(ttl))
 	}); e != nil {
 		return e
 	}
@@ -9,10 +9,15 @@ import (
 	"golang.org/x/sys/windows"
 )
 
-func SetTTL(conn syscall.RawConn, ttl int) error {
+// SetTTL sets the ttl of ipv4 socket, or the unicast hop limit of ipv6 socket
+func SetTTL(conn syscall.RawConn, ttl uint8, ipv6 bool) error {
+	level, opt := windows.IPPROTO_IP, windows.IP_TTL
+	if ipv6 {
+		level, opt = windows.IPPROTO_IPV6, windows.IPV6_UNICAST_HOPS
+	}
 	var err error
 	if e := conn.Control(func(fd uintptr) {
-		err = windows.SetsockoptInt(int(fd), unix.IPPROTO_IP, unix.IP_TTL, ttl)
+		err = windows.SetsockoptInt(windows.Handle(fd), level, opt, int(ttl))
 	}); e != nil {
 		return e
 	}
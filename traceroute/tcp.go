@@ -2,53 +2,79 @@ package traceroute
 
 import (
 	"context"
+	"errors"
 	"net"
+	"sync"
 	"syscall"
+	"time"
+
+	"github.com/joyme123/gnt/utils"
 )
 
 type TCPConn struct {
 	IPv4 bool
 	IPv6 bool
+	// Options are applied to the socket of each probe
+	Options utils.SocketOptions
+
+	// mu serializes the probes, they are bound to the same source port
+	mu sync.Mutex
 }
 
 var _ Conn = &TCPConn{}
+var _ TCPProber = &TCPConn{}
 
-func NewTCPConn(ipv4, ipv6 bool) *TCPConn {
+func NewTCPConn(ipv4, ipv6 bool, opts utils.SocketOptions) *TCPConn {
 	u := &TCPConn{
-		IPv4: ipv4,
-		IPv6: ipv6,
+		IPv4:    ipv4,
+		IPv6:    ipv6,
+		Options: opts,
 	}
 	return u
 }
 
 func (r *TCPConn) SendProbe(ctx context.Context, addr *net.IPAddr, srcPort, dstPort int, ttl uint8, data []byte) error {
+	_, err := r.ProbeTCP(ctx, addr, srcPort, dstPort, ttl, 0)
+	return err
+}
+
+// ProbeTCP connects to remote host, the connection is closed as soon as it's established.
+// The connecting errors except refused are ignored, they are received by icmp connection.
+func (r *TCPConn) ProbeTCP(ctx context.Context, addr *net.IPAddr, srcPort, dstPort int, ttl uint8, timeout time.Duration) (utils.TCPProbeState, error) {
 	localAddr, err := r.getLocalAddr(addr)
 	if err != nil {
-		return err
+		return utils.TCPProbeTimeout, err
 	}
 	dialer := net.Dialer{
+		Timeout: timeout,
 		LocalAddr: &net.TCPAddr{
 			IP:   net.ParseIP(localAddr),
 			Port: srcPort,
 		},
 	}
 	dialer.Control = func(network, address string, c syscall.RawConn) error {
-		if err := SetTTL(c, ttl); err != nil {
+		if err := SetTTL(c, ttl, addr.IP.To4() == nil); err != nil {
 			return err
 		}
-		return nil
+		return r.Options.Apply(c, addr.IP.To4() == nil)
 	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
 	conn, err := dialer.DialContext(ctx, r.tcpNetwork(), (&net.TCPAddr{
 		IP:   addr.IP,
 		Port: dstPort,
 	}).String())
 	if err != nil {
-		return nil
+		if errors.Is(err, syscall.ECONNREFUSED) {
+			return utils.TCPProbeClosed, nil
+		}
+		return utils.TCPProbeTimeout, nil
 	}
 
 	defer conn.Close()
 
-	return nil
+	return utils.TCPProbeOpen, nil
 }
 
 func (r *TCPConn) tcpNetwork() string {
@@ -63,20 +89,9 @@ func (r *TCPConn) tcpNetwork() string {
 }
 
 func (r *TCPConn) getLocalAddr(targetAddr *net.IPAddr) (string, error) {
-	if targetAddr.IP.To4() != nil {
-		// ipv4
-		conn, err := net.Dial("udp", "1.1.1.1:80")
-		if err != nil {
-			return "", err
-		}
-		defer conn.Close()
-		return conn.LocalAddr().(*net.UDPAddr).IP.To4().String(), nil
-	}
-	// ipv6
-	conn, err := net.Dial("udp", "[2606:4700:4700::1111]:80")
+	src, err := utils.SourceAddr(targetAddr.IP, "")
 	if err != nil {
 		return "", err
 	}
-	defer conn.Close()
-	return conn.LocalAddr().(*net.UDPAddr).IP.To16().String(), nil
+	return src.String(), nil
 }
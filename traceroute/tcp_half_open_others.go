@@ -5,8 +5,10 @@ package traceroute
 
 import (
 	"context"
-	"fmt"
 	"net"
+	"time"
+
+	"github.com/joyme123/gnt/utils"
 )
 
 // TCPHalfOpenConn send sync to remote host, if remote host response with ack, then send rst.
@@ -15,19 +17,32 @@ import (
 type TCPHalfOpenConn struct {
 	IPv4 bool
 	IPv6 bool
+	// Options are applied to the socket of each probe
+	Options utils.SocketOptions
 }
 
 var _ Conn = &TCPHalfOpenConn{}
+var _ TCPProber = &TCPHalfOpenConn{}
 
-func NewTCPHalfOpenConn(ipv4, ipv6 bool) *TCPHalfOpenConn {
+func NewTCPHalfOpenConn(ipv4, ipv6 bool, opts utils.SocketOptions) *TCPHalfOpenConn {
 	u := &TCPHalfOpenConn{
-		IPv4: ipv4,
-		IPv6: ipv6,
+		IPv4:    ipv4,
+		IPv6:    ipv6,
+		Options: opts,
 	}
 	return u
 }
 
 func (r *TCPHalfOpenConn) SendProbe(ctx context.Context, addr *net.IPAddr, srcPort, dstPort int, ttl uint8, _ []byte) error {
-	fmt.Println("not implemented yet")
-	return nil
+	_, err := r.ProbeTCP(ctx, addr, srcPort, dstPort, ttl, time.Second)
+	return err
+}
+
+// ProbeTCP falls back to a full tcp connect on this platform, ttl is ignored
+func (r *TCPHalfOpenConn) ProbeTCP(ctx context.Context, addr *net.IPAddr, srcPort, dstPort int, ttl uint8, timeout time.Duration) (utils.TCPProbeState, error) {
+	state, _, err := utils.TCPHalfOpen(ctx, addr.IP, srcPort, dstPort, ttl, timeout, &r.Options)
+	if state == utils.TCPProbeUnreachable {
+		return state, nil
+	}
+	return state, err
 }
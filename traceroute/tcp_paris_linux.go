@@ -0,0 +1,174 @@
+//go:build linux
+// +build linux
+
+package traceroute
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"os"
+	"time"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/joyme123/gnt/utils"
+)
+
+// TCPParisConn sends syn probes of paris traceroute on raw sockets, the identity of probe is
+// the sequence number which can't be chosen for the sockets connecting. The syn-ack of target
+// is reset by kernel because no socket is bound to the source port.
+type TCPParisConn struct {
+	IPv4 bool
+	IPv6 bool
+	// Options are applied to the socket of each probe
+	Options utils.SocketOptions
+
+	// fd is the raw socket receiving the answers of target
+	fd     int
+	target net.IP
+}
+
+// answerPollInterval is the max time of each receiving, so it can be canceled by context
+const answerPollInterval = 100 * time.Millisecond
+
+var _ Conn = &TCPParisConn{}
+var _ ParisConn = &TCPParisConn{}
+var _ AnswerConn = &TCPParisConn{}
+
+func NewTCPParisConn(ipv4, ipv6 bool, opts utils.SocketOptions) *TCPParisConn {
+	u := &TCPParisConn{
+		IPv4:    ipv4,
+		IPv6:    ipv6,
+		Options: opts,
+	}
+	return u
+}
+
+// SendProbe sends the syn probe whose sequence number is 0
+func (r *TCPParisConn) SendProbe(ctx context.Context, addr *net.IPAddr, srcPort, dstPort int, ttl uint8, _ []byte) error {
+	return r.SendParisProbe(ctx, addr, srcPort, dstPort, ttl, 0)
+}
+
+// SendParisProbe sends the syn probe whose sequence number is the identity
+func (r *TCPParisConn) SendParisProbe(ctx context.Context, addr *net.IPAddr, srcPort, dstPort int, ttl uint8, identity int) error {
+	ipv6 := addr.IP.To4() == nil
+	family, level, opt := unix.AF_INET, unix.IPPROTO_IP, unix.IP_TTL
+	if ipv6 {
+		family, level, opt = unix.AF_INET6, unix.IPPROTO_IPV6, unix.IPV6_UNICAST_HOPS
+	}
+	fd, err := unix.Socket(family, unix.SOCK_RAW|unix.SOCK_CLOEXEC, unix.IPPROTO_TCP)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(fd)
+	if err := unix.SetsockoptInt(fd, level, opt, int(ttl)); err != nil {
+		return err
+	}
+	if err := r.Options.ApplyFd(fd, ipv6); err != nil {
+		return err
+	}
+
+	syn := make([]byte, 20)
+	binary.BigEndian.PutUint16(syn[0:2], uint16(srcPort))
+	binary.BigEndian.PutUint16(syn[2:4], uint16(dstPort))
+	binary.BigEndian.PutUint32(syn[4:8], uint32(identity))
+	// data offset is 5 words, and only syn flag is set
+	syn[12] = 5 << 4
+	syn[13] = 0x02
+	binary.BigEndian.PutUint16(syn[14:16], 0xffff)
+
+	var sa unix.Sockaddr
+	if ipv6 {
+		// the checksum of raw ipv6 socket is calculated by kernel with the offset
+		if err := unix.SetsockoptInt(fd, unix.IPPROTO_IPV6, unix.IPV6_CHECKSUM, 16); err != nil {
+			return err
+		}
+		sa6 := &unix.SockaddrInet6{}
+		copy(sa6.Addr[:], addr.IP.To16())
+		if addr.Zone != "" {
+			if intf, err := net.InterfaceByName(addr.Zone); err == nil {
+				sa6.ZoneId = uint32(intf.Index)
+			}
+		}
+		sa = sa6
+	} else {
+		src, err := utils.SourceAddr(addr.IP, r.Options.BindDevice)
+		if err != nil {
+			return err
+		}
+		binary.BigEndian.PutUint16(syn[16:18], checksum(pseudoHeaderSum(src, addr.IP, 6, len(syn)), syn))
+		sa4 := &unix.SockaddrInet4{}
+		copy(sa4.Addr[:], addr.IP.To4())
+		sa = sa4
+	}
+
+	return unix.Sendto(fd, syn, 0, sa)
+}
+
+// ListenAnswers opens the raw socket receiving the syn-ack and rst of target
+func (r *TCPParisConn) ListenAnswers(addr *net.IPAddr) error {
+	ipv6 := addr.IP.To4() == nil
+	family := unix.AF_INET
+	if ipv6 {
+		family = unix.AF_INET6
+	}
+	fd, err := unix.Socket(family, unix.SOCK_RAW|unix.SOCK_CLOEXEC, unix.IPPROTO_TCP)
+	if err != nil {
+		return os.NewSyscallError("socket", err)
+	}
+	// the receiving is canceled by context in time
+	tv := unix.NsecToTimeval(answerPollInterval.Nanoseconds())
+	if err := unix.SetsockoptTimeval(fd, unix.SOL_SOCKET, unix.SO_RCVTIMEO, &tv); err != nil {
+		unix.Close(fd)
+		return os.NewSyscallError("setsockopt rcvtimeo", err)
+	}
+	if r.Options.BindDevice != "" {
+		if err := unix.BindToDevice(fd, r.Options.BindDevice); err != nil {
+			unix.Close(fd)
+			return os.NewSyscallError("setsockopt bind device", err)
+		}
+	}
+	r.fd = fd
+	r.target = addr.IP
+	return nil
+}
+
+// ReceiveAnswers receives the syn-ack and rst of target to the source ports of probes, the
+// identity of probe is the acknowledgment number minus 1. It returns when ctx is done.
+func (r *TCPParisConn) ReceiveAnswers(ctx context.Context, isSrcPort func(port int) bool, onAnswer func(identity int)) error {
+	defer unix.Close(r.fd)
+
+	ipv6 := r.target.To4() == nil
+	buf := make([]byte, 1500)
+	for ctx.Err() == nil {
+		n, from, err := unix.Recvfrom(r.fd, buf, 0)
+		if err != nil {
+			if err == unix.EAGAIN || err == unix.EINTR {
+				continue
+			}
+			return os.NewSyscallError("recvfrom", err)
+		}
+
+		var src net.IP
+		seg := buf[:n]
+		switch sa := from.(type) {
+		case *unix.SockaddrInet4:
+			src = net.IP(sa.Addr[:])
+		case *unix.SockaddrInet6:
+			src = net.IP(sa.Addr[:])
+		}
+		// the ip header is received by raw ipv4 socket
+		if !ipv6 && n > 0 {
+			ihl := int(seg[0]&0x0f) * 4
+			if ihl > n {
+				continue
+			}
+			seg = seg[ihl:]
+		}
+		if identity, ok := parseTCPAnswer(seg, isSrcPort); ok && src.Equal(r.target) {
+			onAnswer(identity)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+//go:build windows || darwin
+// +build windows darwin
+
+package traceroute
+
+import (
+	"context"
+	"errors"
+	"net"
+
+	"github.com/joyme123/gnt/utils"
+)
+
+// TCPParisConn sends syn probes of paris traceroute on raw sockets, the identity of probe is
+// the sequence number which can't be chosen for the sockets connecting.
+type TCPParisConn struct {
+	IPv4 bool
+	IPv6 bool
+	// Options are applied to the socket of each probe
+	Options utils.SocketOptions
+}
+
+var _ Conn = &TCPParisConn{}
+var _ ParisConn = &TCPParisConn{}
+var _ AnswerConn = &TCPParisConn{}
+
+func NewTCPParisConn(ipv4, ipv6 bool, opts utils.SocketOptions) *TCPParisConn {
+	u := &TCPParisConn{
+		IPv4:    ipv4,
+		IPv6:    ipv6,
+		Options: opts,
+	}
+	return u
+}
+
+// SendProbe sends the syn probe whose sequence number is 0
+func (r *TCPParisConn) SendProbe(ctx context.Context, addr *net.IPAddr, srcPort, dstPort int, ttl uint8, _ []byte) error {
+	return r.SendParisProbe(ctx, addr, srcPort, dstPort, ttl, 0)
+}
+
+func (r *TCPParisConn) SendParisProbe(ctx context.Context, addr *net.IPAddr, srcPort, dstPort int, ttl uint8, identity int) error {
+	return errParisTCPUnsupported
+}
+
+func (r *TCPParisConn) ListenAnswers(addr *net.IPAddr) error {
+	return errParisTCPUnsupported
+}
+
+func (r *TCPParisConn) ReceiveAnswers(ctx context.Context, isSrcPort func(port int) bool, onAnswer func(identity int)) error {
+	return errParisTCPUnsupported
+}
+
+var errParisTCPUnsupported = errors.New("paris traceroute with tcp is not supported on this platform")
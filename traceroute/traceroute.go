@@ -2,22 +2,38 @@ package traceroute
 
 import (
 	"context"
+	"io"
 	"net"
 	"os"
 	"runtime"
+	"sync"
 	"time"
 
 	"github.com/go-logr/logr"
 	"golang.org/x/sync/errgroup"
+
+	"github.com/joyme123/gnt/utils"
 )
 
 type Conn interface {
 	SendProbe(ctx context.Context, addr *net.IPAddr, srcPort, dstPort int, ttl uint8, data []byte) error
 }
 
-type PacketInfo struct {
-	IP  string
-	RTT int
+// AnswerConn is a Conn receiving the answers of target which are not icmp messages, like
+// the syn-ack or rst of paris tcp probes.
+type AnswerConn interface {
+	// ListenAnswers opens the connection receiving the answers of target, it's called
+	// before sending any probe.
+	ListenAnswers(addr *net.IPAddr) error
+	// ReceiveAnswers calls onAnswer with the identity of each answered probe sent from the
+	// source ports matched by isSrcPort, it returns when ctx is done.
+	ReceiveAnswers(ctx context.Context, isSrcPort func(port int) bool, onAnswer func(identity int)) error
+}
+
+// TCPProber sends tcp probes and waits for the answer of target until timeout. The syn-ack
+// or rst of target is never delivered to icmp connection, so it's known by the state.
+type TCPProber interface {
+	ProbeTCP(ctx context.Context, addr *net.IPAddr, srcPort, dstPort int, ttl uint8, timeout time.Duration) (utils.TCPProbeState, error)
 }
 
 type TraceRouter struct {
@@ -38,38 +54,64 @@ type TraceRouter struct {
 	Squeries int
 	// Sets the number of probe packets per hop. The default is 3.
 	Nqueries int
-	// Set the time (in seconds) to wait for a response to a probe (default 5.0 sec).
-	WaitTime int
+	// Set the time to wait for a response to a probe (default 5.0 sec).
+	WaitTime time.Duration
 	// Minimal time interval between probes (default 0).
-	// If the value is more than 10, then it specifies a number in milliseconds,
-	// else it is a number of seconds (float point values allowed too).
+	// If the value is more than 10 seconds, then it specifies a number in milliseconds,
+	// e.g. 20 seconds means 20 milliseconds, else it is a number of seconds.
 	// Useful when some routers use rate-limit for icmp messages.
-	SendWait int
+	SendWait time.Duration
 
 	DstAddr string
 
 	Unprivileged bool
+	// MTU discovers the mtu along the path
+	MTU bool
+	// Paris keeps the five-tuple of probes constant, the identity of probe is encoded in the
+	// fields ignored by load balancers
+	Paris bool
+	// Multipath discovers all the load balanced paths to target, it implies Paris
+	Multipath bool
+	// Confidence is the confidence level of multipath detection. Defaults to 0.95.
+	Confidence float64
+
+	socketOptions utils.SocketOptions
 
-	ttl       uint8
 	conn      Conn
 	method    string
 	startPort int
-
-	sendPacketsTimestamps map[uint8][]time.Time
-	statistics            map[uint8]map[int]*PacketInfo
-
-	printTTL  uint8
-	printAddr int
+	// echoID is the id of icmp probes, it's the id of pinger sharing its connection
+	echoID int
+	// dstAddr is the resolved address of DstAddr
+	dstAddr *net.IPAddr
+
+	// mu protects the probes shared by sender and receiver
+	mu sync.Mutex
+	// probes are indexed by their identity, which is the number of probes sent before
+	probes []*probe
+	// inflight is the number of probes neither answered nor timed out
+	inflight int
+	// destTTL is the least ttl reaching the target, 0 if it's not reached yet
+	destTTL uint8
+	// printed is the number of probes printed
+	printed int
+	// updated wakes up the sender when a probe is answered
+	updated chan struct{}
+	// sendErr receives the error of probes sent asynchronously
+	sendErr chan error
+	// out is where the hops are printed
+	out io.Writer
 
 	debugLogger logr.Logger
 }
 
 func NewTraceRouter(opt Options, dst string, debugLogger logr.Logger) *TraceRouter {
 	r := &TraceRouter{
-		DstAddr:               dst,
-		sendPacketsTimestamps: make(map[uint8][]time.Time),
-		statistics:            make(map[uint8]map[int]*PacketInfo),
-		debugLogger:           debugLogger,
+		DstAddr:     dst,
+		updated:     make(chan struct{}, 1),
+		sendErr:     make(chan error, 1),
+		out:         os.Stdout,
+		debugLogger: debugLogger,
 	}
 	r.initDefaultOpts(opt)
 	return r
@@ -80,6 +122,11 @@ func (r *TraceRouter) initDefaultOpts(opt Options) {
 		r.Port = opt.Port
 	} else if opt.UDP {
 		r.Port = 53
+	} else if opt.ICMP {
+		r.Port = 1
+	} else if opt.TCP && (opt.Paris || opt.Multipath) {
+		// the destination port of paris tcp probes is constant
+		r.Port = 80
 	} else {
 		r.Port = 33434
 	}
@@ -87,43 +134,72 @@ func (r *TraceRouter) initDefaultOpts(opt Options) {
 
 	r.IPv4 = opt.IPv4
 	r.IPv6 = opt.IPv6
-	r.FirstTTL = opt.FirstTTL
-	r.MaxTTL = opt.MaxTTL
-	r.Squeries = opt.Squeries
-	r.Nqueries = opt.Nqueries
+	r.FirstTTL = 1
+	if opt.FirstTTL > 0 {
+		r.FirstTTL = opt.FirstTTL
+	}
+	r.MaxTTL = 30
+	if opt.MaxTTL > 0 {
+		r.MaxTTL = opt.MaxTTL
+	}
+	r.Squeries = 16
+	if opt.Squeries > 0 {
+		r.Squeries = opt.Squeries
+	}
+	r.Nqueries = 3
+	if opt.Nqueries > 0 {
+		r.Nqueries = opt.Nqueries
+	}
 
-	r.WaitTime = 5
+	r.WaitTime = 5 * time.Second
 	if opt.WaitTime > 0 {
 		r.WaitTime = opt.WaitTime
 	}
 	r.SendWait = opt.SendWait
 
-	r.Unprivileged = opt.Unprivileged
+	r.MTU = opt.MTU
+	// multipath probes are the paris probes of several flows
+	r.Paris = opt.Paris || opt.Multipath
+	r.Multipath = opt.Multipath
+	r.Confidence = 0.95
+	if opt.Confidence > 0 {
+		r.Confidence = opt.Confidence
+	}
+	r.socketOptions = opt.SocketOptions
 
 	if opt.ICMP {
 		r.method = "icmp"
 	} else if opt.UDP {
-		r.conn = NewUDPConn(r.IPv4, r.IPv6)
+		r.conn = NewUDPConn(r.IPv4, r.IPv6, opt.SocketOptions)
 		r.method = "udp"
 	} else if opt.TCP {
 		r.method = "tcp"
-		if runtime.GOOS == "linux" {
+		if r.Paris {
+			r.conn = NewTCPParisConn(r.IPv4, r.IPv6, opt.SocketOptions)
+		} else if runtime.GOOS == "linux" {
 			r.debugLogger.V(4).Info("use tcp half open connection")
-			r.conn = NewTCPHalfOpenConn(r.IPv4, r.IPv6)
+			r.conn = NewTCPHalfOpenConn(r.IPv4, r.IPv6, opt.SocketOptions)
 		} else {
-			r.conn = NewTCPConn(r.IPv4, r.IPv6)
+			r.conn = NewTCPConn(r.IPv4, r.IPv6, opt.SocketOptions)
 		}
 	} else {
-		r.conn = NewUDPConn(r.IPv4, r.IPv6)
+		r.conn = NewUDPConn(r.IPv4, r.IPv6, opt.SocketOptions)
 		r.method = "default"
 	}
-
-	r.ttl = r.FirstTTL
-	r.printTTL = r.FirstTTL
-	r.printAddr = 0
+	// FIXME(jpf): can't receive ttl exceeded response of udp and tcp probes by datagram icmp
+	// socket on linux, the icmp errors are sent to the socket of each probe. So unprivileged
+	// mode is ignored for them.
+	r.Unprivileged = opt.Unprivileged && (r.method == "icmp" || r.MTU)
 }
 
 func (r *TraceRouter) Run(ctx context.Context) error {
+	if _, err := r.resolvAddr(); err != nil {
+		return err
+	}
+	if r.MTU {
+		return r.runMTU(ctx)
+	}
+
 	var cancel context.CancelFunc
 	ctx, cancel = context.WithCancel(ctx)
 
@@ -139,84 +215,18 @@ func (r *TraceRouter) Run(ctx context.Context) error {
 	g.Go(func() error {
 		defer cancel()
 		<-c
+		if r.Multipath {
+			return r.SendMultipath(ctx)
+		}
 		return r.Send(ctx)
 	})
 
 	err := g.Wait()
 
-	r.printStatistic()
+	r.flush()
 	return err
 }
 
-func (r *TraceRouter) Send(ctx context.Context) error {
-	addr, err := r.resolvAddr()
-	if err != nil {
-		return err
-	}
-
-	if ok, err := r.sendProbe(ctx, addr); err != nil {
-		return err
-	} else if !ok {
-		return nil
-	}
-
-	for {
-		select {
-		case <-ctx.Done():
-			return nil
-		default:
-			if r.complete() {
-				return nil
-			}
-
-			if !r.continueToRun() {
-				r.updateStatistic(r.ttl, 0, "")
-				time.Sleep(50 * time.Millisecond)
-				continue
-			}
-			if ok, err := r.sendProbe(ctx, addr); err != nil {
-				return err
-			} else if !ok {
-				return nil
-			}
-		}
-	}
-}
-
-func (r *TraceRouter) continueToRun() bool {
-	return len(r.statistics) == int(r.ttl-1) && len(r.statistics[r.ttl-1]) >= 3
-}
-
-func (r *TraceRouter) complete() bool {
-	if len(r.statistics) == int(r.ttl-1) {
-		for i := range r.statistics[r.ttl-1] {
-			if r.statistics[r.ttl-1][i].IP == r.DstAddr {
-				return true
-			}
-		}
-	}
-	return false
-}
-
-func (r *TraceRouter) sendProbe(ctx context.Context, addr *net.IPAddr) (bool, error) {
-	for i := 0; i < 3; i++ {
-		r.sendPacketsTimestamps[r.ttl] = append(r.sendPacketsTimestamps[r.ttl], time.Now())
-		if err := r.conn.SendProbe(ctx, addr, r.id(), r.Port, r.ttl, []byte{0x00, uint8(i)}); err != nil {
-			return false, err
-		}
-		r.Port++
-		// send too fast will cause icmp drop
-		time.Sleep(100 * time.Millisecond)
-	}
-
-	r.updateStatistic(r.ttl, 0, "")
-	r.ttl++
-	if r.ttl > r.MaxTTL {
-		return false, nil
-	}
-	return true, nil
-}
-
 func (r *TraceRouter) resolvAddr() (*net.IPAddr, error) {
 	network := "ip"
 	if r.IPv4 {
@@ -236,10 +246,32 @@ func (r *TraceRouter) resolvAddr() (*net.IPAddr, error) {
 		r.IPv6 = true
 		r.IPv4 = false
 	}
+	r.dstAddr = ipaddr
 
 	return ipaddr, nil
 }
 
 func (r *TraceRouter) id() int {
+	if r.echoID != 0 {
+		return r.echoID
+	}
 	return (os.Getpid() & 0xffff) | 0x8000
 }
+
+// flowPorts returns the ports of probes of flow. The tcp flows of multipath probes differ in
+// the source port so that the service probed is kept, the source ports of them are aligned to
+// maxFlows so they don't overflow. The other flows differ in the destination port.
+func (r *TraceRouter) flowPorts(flow int) (srcPort, dstPort int) {
+	if r.method == "tcp" && r.Multipath {
+		return r.id()&^(maxFlows-1) + flow, r.startPort
+	}
+	return r.id(), r.startPort + flow
+}
+
+// isSrcPort returns true if port is the source port of probes of any flow
+func (r *TraceRouter) isSrcPort(port int) bool {
+	if r.method == "tcp" && r.Multipath {
+		return port&^(maxFlows-1) == r.id()&^(maxFlows-1)
+	}
+	return port == r.id()
+}
@@ -3,51 +3,78 @@ package traceroute
 import (
 	"context"
 	"net"
+	"syscall"
+
+	"github.com/joyme123/gnt/utils"
 )
 
 type UDPConn struct {
 	IPv4 bool
 	IPv6 bool
+	// Options are applied to the socket of each probe
+	Options utils.SocketOptions
 }
 
 var _ Conn = &UDPConn{}
+var _ ParisConn = &UDPConn{}
 
-func NewUDPConn(ipv4, ipv6 bool) *UDPConn {
+func NewUDPConn(ipv4, ipv6 bool, opts utils.SocketOptions) *UDPConn {
 	u := &UDPConn{
-		IPv4: ipv4,
-		IPv6: ipv6,
+		IPv4:    ipv4,
+		IPv6:    ipv6,
+		Options: opts,
 	}
 	return u
 }
 
 func (r *UDPConn) SendProbe(ctx context.Context, addr *net.IPAddr, srcPort, dstPort int, ttl uint8, data []byte) error {
-	udpConn, err := net.DialUDP(r.udpNetwork(), &net.UDPAddr{
-		Port: srcPort,
-	}, &net.UDPAddr{
-		IP:   addr.IP,
-		Port: dstPort,
-	})
+	udpConn, err := r.dial(ctx, addr, srcPort, dstPort, ttl)
 	if err != nil {
 		return err
 	}
 
 	defer udpConn.Close()
 
-	syscallConn, err := udpConn.SyscallConn()
+	_, err = udpConn.Write(data)
 	if err != nil {
 		return err
 	}
 
-	if err := SetTTL(syscallConn, ttl); err != nil {
-		return err
-	}
+	return nil
+}
 
-	_, err = udpConn.Write(data)
+// SendParisProbe sends the probe whose checksum is the identity plus 1. The checksum quoted
+// by routers is only partial if it's offloaded to a virtual interface never calculating it.
+func (r *UDPConn) SendParisProbe(ctx context.Context, addr *net.IPAddr, srcPort, dstPort int, ttl uint8, identity int) error {
+	udpConn, err := r.dial(ctx, addr, srcPort, dstPort, ttl)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	defer udpConn.Close()
+
+	// the source address is chosen by connecting
+	src := udpConn.LocalAddr().(*net.UDPAddr).IP
+	_, err = udpConn.Write(udpParisPayload(src, addr.IP, srcPort, dstPort, identity))
+	return err
+}
+
+func (r *UDPConn) dial(ctx context.Context, addr *net.IPAddr, srcPort, dstPort int, ttl uint8) (net.Conn, error) {
+	dialer := net.Dialer{
+		LocalAddr: &net.UDPAddr{
+			Port: srcPort,
+		},
+		Control: func(network, address string, c syscall.RawConn) error {
+			if err := SetTTL(c, ttl, addr.IP.To4() == nil); err != nil {
+				return err
+			}
+			return r.Options.Apply(c, addr.IP.To4() == nil)
+		},
+	}
+	return dialer.DialContext(ctx, r.udpNetwork(), (&net.UDPAddr{
+		IP:   addr.IP,
+		Port: dstPort,
+	}).String())
 }
 
 func (r *UDPConn) udpNetwork() string {
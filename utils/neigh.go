@@ -0,0 +1,54 @@
+package utils
+
+import "net"
+
+// NeighborState is the state of neighbor entry, RFC 4861
+type NeighborState int
+
+// States of neighbor entry
+const (
+	// NeighborNone is an unknown state
+	NeighborNone NeighborState = iota
+	// NeighborIncomplete is resolving the link layer address
+	NeighborIncomplete
+	// NeighborReachable is confirmed reachable recently
+	NeighborReachable
+	// NeighborStale has a link layer address which is not confirmed recently
+	NeighborStale
+	// NeighborDelay waits for the confirmation by upper layer before probing
+	NeighborDelay
+	// NeighborProbe is probing the link layer address
+	NeighborProbe
+	// NeighborFailed failed to resolve the link layer address
+	NeighborFailed
+	// NeighborPermanent is a static entry
+	NeighborPermanent
+)
+
+// Neighbor is an entry of neighbor table, which is the arp table of ipv4 or the neighbor
+// cache of ipv6
+type Neighbor struct {
+	IP           net.IP
+	HardwareAddr net.HardwareAddr
+	// Index is the index of interface
+	Index int
+	State NeighborState
+}
+
+// Resolving returns true if the link layer address is being resolved or confirmed
+func (n *Neighbor) Resolving() bool {
+	switch n.State {
+	case NeighborIncomplete, NeighborDelay, NeighborProbe:
+		return true
+	}
+	return false
+}
+
+// Resolved returns true if the neighbor answered the resolution and isn't known to be gone
+func (n *Neighbor) Resolved() bool {
+	switch n.State {
+	case NeighborReachable, NeighborStale, NeighborPermanent:
+		return len(n.HardwareAddr) > 0
+	}
+	return false
+}
@@ -0,0 +1,127 @@
+//go:build linux
+// +build linux
+
+package utils
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// neighSeq is the sequence number of neighbor request
+const neighSeq = 2
+
+// Neighbors dumps the neighbor tables of ipv4 and ipv6 by netlink
+func Neighbors() ([]Neighbor, error) {
+	fd, err := dialNetlink()
+	if err != nil {
+		return nil, err
+	}
+	defer unix.Close(fd)
+
+	b := make([]byte, unix.SizeofNlMsghdr+unix.SizeofNdMsg)
+	*(*unix.NlMsghdr)(unsafe.Pointer(&b[0])) = unix.NlMsghdr{
+		Len:   uint32(len(b)),
+		Type:  unix.RTM_GETNEIGH,
+		Flags: unix.NLM_F_REQUEST | unix.NLM_F_DUMP,
+		Seq:   neighSeq,
+	}
+	if err := unix.Sendto(fd, b, 0, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+		return nil, os.NewSyscallError("sendto", err)
+	}
+
+	var neighbors []Neighbor
+	// a message of dump may be larger than a page
+	buf := make([]byte, 64*1024)
+	for {
+		n, _, err := unix.Recvfrom(fd, buf, 0)
+		if err != nil {
+			return nil, os.NewSyscallError("recvfrom", err)
+		}
+		msgs, err := syscall.ParseNetlinkMessage(buf[:n])
+		if err != nil {
+			return nil, err
+		}
+		for i := range msgs {
+			msg := &msgs[i]
+			if msg.Header.Seq != neighSeq {
+				continue
+			}
+			switch msg.Header.Type {
+			case unix.NLMSG_DONE:
+				return neighbors, nil
+			case unix.NLMSG_ERROR:
+				if len(msg.Data) < unix.SizeofNlMsgerr {
+					return nil, fmt.Errorf("neighbors: truncated netlink error")
+				}
+				nlerr := (*unix.NlMsgerr)(unsafe.Pointer(&msg.Data[0]))
+				return nil, fmt.Errorf("neighbors: %w", syscall.Errno(-nlerr.Error))
+			case unix.RTM_NEWNEIGH:
+				if neigh := parseNeighbor(msg.Data); neigh != nil {
+					neighbors = append(neighbors, *neigh)
+				}
+			}
+		}
+	}
+}
+
+// parseNeighbor parses ndmsg and the attributes of destination and link layer address
+func parseNeighbor(b []byte) *Neighbor {
+	if len(b) < unix.SizeofNdMsg {
+		return nil
+	}
+	nd := (*unix.NdMsg)(unsafe.Pointer(&b[0]))
+	neigh := &Neighbor{
+		Index: int(nd.Ifindex),
+		State: neighborState(nd.State),
+	}
+
+	b = b[unix.SizeofNdMsg:]
+	for len(b) >= unix.SizeofRtAttr {
+		attr := (*unix.RtAttr)(unsafe.Pointer(&b[0]))
+		if int(attr.Len) < unix.SizeofRtAttr || int(attr.Len) > len(b) {
+			break
+		}
+		value := b[unix.SizeofRtAttr:attr.Len]
+		switch attr.Type {
+		case unix.NDA_DST:
+			neigh.IP = net.IP(append([]byte(nil), value...))
+		case unix.NDA_LLADDR:
+			neigh.HardwareAddr = net.HardwareAddr(append([]byte(nil), value...))
+		}
+		if l := rtaAlign(int(attr.Len)); l < len(b) {
+			b = b[l:]
+		} else {
+			break
+		}
+	}
+	if neigh.IP == nil {
+		return nil
+	}
+	return neigh
+}
+
+func neighborState(state uint16) NeighborState {
+	switch {
+	case state&unix.NUD_PERMANENT != 0:
+		return NeighborPermanent
+	case state&unix.NUD_REACHABLE != 0:
+		return NeighborReachable
+	case state&unix.NUD_STALE != 0:
+		return NeighborStale
+	case state&unix.NUD_DELAY != 0:
+		return NeighborDelay
+	case state&unix.NUD_PROBE != 0:
+		return NeighborProbe
+	case state&unix.NUD_INCOMPLETE != 0:
+		return NeighborIncomplete
+	case state&unix.NUD_FAILED != 0:
+		return NeighborFailed
+	}
+	return NeighborNone
+}
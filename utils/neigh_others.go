@@ -0,0 +1,11 @@
+//go:build !linux
+// +build !linux
+
+package utils
+
+import "fmt"
+
+// Neighbors returns the entries of neighbor table, it's not supported on this platform
+func Neighbors() ([]Neighbor, error) {
+	return nil, fmt.Errorf("neighbor table is not supported")
+}
@@ -13,3 +13,39 @@ func IPAddrString(addr net.Addr) string {
 
 	return ""
 }
+
+// IsBroadcast returns true if ip is the limited broadcast address, or the broadcast
+// address of a subnet which local host is attached to.
+func IsBroadcast(ip net.IP) bool {
+	ip = ip.To4()
+	if ip == nil {
+		return false
+	}
+	if ip.Equal(net.IPv4bcast) {
+		return true
+	}
+
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return false
+	}
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok || ipNet.IP.To4() == nil || len(ipNet.Mask) != net.IPv4len {
+			continue
+		}
+		ones, _ := ipNet.Mask.Size()
+		if ones >= 31 {
+			// point to point links have no broadcast address, RFC 3021
+			continue
+		}
+		bcast := make(net.IP, net.IPv4len)
+		for i := range bcast {
+			bcast[i] = ipNet.IP.To4()[i] | ^ipNet.Mask[i]
+		}
+		if ip.Equal(bcast) {
+			return true
+		}
+	}
+	return false
+}
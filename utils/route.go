@@ -0,0 +1,92 @@
+package utils
+
+import (
+	"fmt"
+	"net"
+)
+
+// Route is the route chosen by kernel to reach a destination
+type Route struct {
+	// Src is the preferred source address
+	Src net.IP
+	// Gateway is the next hop, it's nil if the destination is directly connected
+	Gateway net.IP
+	// Interface is the egress interface, it's nil if unknown
+	Interface *net.Interface
+}
+
+// SourceAddr returns the source address to reach dst. dev can be an interface name, then
+// the source address is an address of the interface, or an ip address which is used as
+// the source address directly.
+func SourceAddr(dst net.IP, dev string) (net.IP, error) {
+	if dev == "" {
+		r, err := LookupRoute(dst, nil)
+		if err != nil {
+			return nil, err
+		}
+		return r.Src, nil
+	}
+
+	if ip := net.ParseIP(dev); ip != nil {
+		if (ip.To4() != nil) != (dst.To4() != nil) {
+			return nil, fmt.Errorf("source address %s and destination %s are not in the same address family", dev, dst)
+		}
+		return ip, nil
+	}
+
+	intf, err := net.InterfaceByName(dev)
+	if err != nil {
+		return nil, err
+	}
+	if r, err := LookupRoute(dst, intf); err == nil && r.Src != nil {
+		return r.Src, nil
+	}
+	return InterfaceAddr(intf, dst.To4() != nil)
+}
+
+// InterfaceAddr returns an ipv4 or ipv6 address of the interface, global unicast addresses
+// are preferred over link local addresses.
+func InterfaceAddr(intf *net.Interface, ipv4 bool) (net.IP, error) {
+	addrs, err := intf.Addrs()
+	if err != nil {
+		return nil, err
+	}
+
+	var found net.IP
+	for _, addr := range addrs {
+		ipnet, ok := addr.(*net.IPNet)
+		if !ok || (ipnet.IP.To4() != nil) != ipv4 {
+			continue
+		}
+		if ipnet.IP.IsGlobalUnicast() {
+			return ipnet.IP, nil
+		}
+		if found == nil {
+			found = ipnet.IP
+		}
+	}
+	if found == nil {
+		family := "ipv6"
+		if ipv4 {
+			family = "ipv4"
+		}
+		return nil, fmt.Errorf("interface %s doesn't have any %s address", intf.Name, family)
+	}
+	return found, nil
+}
+
+// lookupRouteByDial lets kernel choose the source address by connecting an udp socket to
+// the destination, no packet is sent.
+func lookupRouteByDial(dst net.IP) (*Route, error) {
+	network := "udp6"
+	if dst.To4() != nil {
+		network = "udp4"
+	}
+	conn, err := net.DialUDP(network, nil, &net.UDPAddr{IP: dst, Port: 9})
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	return &Route{Src: conn.LocalAddr().(*net.UDPAddr).IP}, nil
+}
@@ -0,0 +1,150 @@
+//go:build linux
+// +build linux
+
+package utils
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// routeSeq is the sequence number of route request
+const routeSeq = 1
+
+// LookupRoute asks kernel for the route to dst by netlink, so policy routing is respected.
+// If intf is not nil, the route must go through it. It falls back to connecting an udp
+// socket if netlink is not available.
+func LookupRoute(dst net.IP, intf *net.Interface) (*Route, error) {
+	r, err := netlinkRoute(dst, intf)
+	if intf == nil && (err != nil || r.Src == nil) {
+		return lookupRouteByDial(dst)
+	}
+	return r, err
+}
+
+// dialNetlink opens a route netlink socket, the caller must close it
+func dialNetlink() (int, error) {
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_RAW|unix.SOCK_CLOEXEC, unix.NETLINK_ROUTE)
+	if err != nil {
+		return -1, os.NewSyscallError("socket", err)
+	}
+	if err := unix.Bind(fd, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+		unix.Close(fd)
+		return -1, os.NewSyscallError("bind", err)
+	}
+	return fd, nil
+}
+
+func netlinkRoute(dst net.IP, intf *net.Interface) (*Route, error) {
+	fd, err := dialNetlink()
+	if err != nil {
+		return nil, err
+	}
+	defer unix.Close(fd)
+
+	sa := &unix.SockaddrNetlink{Family: unix.AF_NETLINK}
+	if err := unix.Sendto(fd, routeRequest(dst, intf), 0, sa); err != nil {
+		return nil, os.NewSyscallError("sendto", err)
+	}
+
+	buf := make([]byte, os.Getpagesize())
+	for {
+		n, _, err := unix.Recvfrom(fd, buf, 0)
+		if err != nil {
+			return nil, os.NewSyscallError("recvfrom", err)
+		}
+		msgs, err := syscall.ParseNetlinkMessage(buf[:n])
+		if err != nil {
+			return nil, err
+		}
+		for i := range msgs {
+			msg := &msgs[i]
+			if msg.Header.Seq != routeSeq {
+				continue
+			}
+			switch msg.Header.Type {
+			case unix.NLMSG_ERROR:
+				if len(msg.Data) < unix.SizeofNlMsgerr {
+					return nil, fmt.Errorf("route to %s: truncated netlink error", dst)
+				}
+				nlerr := (*unix.NlMsgerr)(unsafe.Pointer(&msg.Data[0]))
+				return nil, fmt.Errorf("route to %s: %w", dst, syscall.Errno(-nlerr.Error))
+			case unix.RTM_NEWROUTE:
+				return parseRoute(msg)
+			}
+		}
+	}
+}
+
+// routeRequest builds the RTM_GETROUTE message: nlmsghdr, rtmsg and the attributes of
+// destination and output interface.
+func routeRequest(dst net.IP, intf *net.Interface) []byte {
+	family, ip := unix.AF_INET6, dst.To16()
+	if ip4 := dst.To4(); ip4 != nil {
+		family, ip = unix.AF_INET, ip4
+	}
+
+	b := make([]byte, unix.SizeofNlMsghdr+unix.SizeofRtMsg)
+	*(*unix.RtMsg)(unsafe.Pointer(&b[unix.SizeofNlMsghdr])) = unix.RtMsg{
+		Family:  uint8(family),
+		Dst_len: uint8(len(ip) * 8),
+	}
+	b = appendRouteAttr(b, unix.RTA_DST, ip)
+	if intf != nil {
+		oif := make([]byte, 4)
+		*(*uint32)(unsafe.Pointer(&oif[0])) = uint32(intf.Index)
+		b = appendRouteAttr(b, unix.RTA_OIF, oif)
+	}
+	*(*unix.NlMsghdr)(unsafe.Pointer(&b[0])) = unix.NlMsghdr{
+		Len:   uint32(len(b)),
+		Type:  unix.RTM_GETROUTE,
+		Flags: unix.NLM_F_REQUEST,
+		Seq:   routeSeq,
+	}
+	return b
+}
+
+func appendRouteAttr(b []byte, typ uint16, data []byte) []byte {
+	attr := make([]byte, rtaAlign(unix.SizeofRtAttr+len(data)))
+	*(*unix.RtAttr)(unsafe.Pointer(&attr[0])) = unix.RtAttr{
+		Len:  uint16(unix.SizeofRtAttr + len(data)),
+		Type: typ,
+	}
+	copy(attr[unix.SizeofRtAttr:], data)
+	return append(b, attr...)
+}
+
+func rtaAlign(n int) int {
+	return (n + unix.RTA_ALIGNTO - 1) &^ (unix.RTA_ALIGNTO - 1)
+}
+
+func parseRoute(msg *syscall.NetlinkMessage) (*Route, error) {
+	attrs, err := syscall.ParseNetlinkRouteAttr(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Route{}
+	for _, attr := range attrs {
+		switch attr.Attr.Type {
+		case unix.RTA_PREFSRC:
+			r.Src = net.IP(attr.Value)
+		case unix.RTA_GATEWAY:
+			r.Gateway = net.IP(attr.Value)
+		case unix.RTA_OIF:
+			if len(attr.Value) < 4 {
+				continue
+			}
+			index := *(*uint32)(unsafe.Pointer(&attr.Value[0]))
+			if intf, err := net.InterfaceByIndex(int(index)); err == nil {
+				r.Interface = intf
+			}
+		}
+	}
+	return r, nil
+}
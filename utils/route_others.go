@@ -0,0 +1,18 @@
+//go:build !linux
+// +build !linux
+
+package utils
+
+import (
+	"fmt"
+	"net"
+)
+
+// LookupRoute returns the route to dst. Only the source address is known on this platform,
+// and the route can't be restricted to an interface.
+func LookupRoute(dst net.IP, intf *net.Interface) (*Route, error) {
+	if intf != nil {
+		return nil, fmt.Errorf("route lookup by interface is not supported")
+	}
+	return lookupRouteByDial(dst)
+}
@@ -0,0 +1,61 @@
+package utils
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// Path mtu discovery strategies
+const (
+	// PMTUDiscDo sets DF and prohibits fragmentation
+	PMTUDiscDo = "do"
+	// PMTUDiscDont allows fragmentation
+	PMTUDiscDont = "dont"
+	// PMTUDiscWant does path mtu discovery, and fragments the packets larger than path mtu
+	PMTUDiscWant = "want"
+	// PMTUDiscProbe sets DF but ignores the path mtu known by kernel
+	PMTUDiscProbe = "probe"
+)
+
+// SocketOptions are the options of probe sockets, so probes follow the same path as the
+// real traffic. Zero values leave the system defaults.
+type SocketOptions struct {
+	// TOS is the type of service of ipv4, or the traffic class of ipv6, which contains
+	// the DSCP and ECN bits
+	TOS int
+	// Mark is the firewall mark used by policy routing
+	Mark int
+	// BindDevice is the interface or VRF the socket is bound to
+	BindDevice string
+	// PMTUDisc is the path mtu discovery strategy: do, dont, want or probe
+	PMTUDisc string
+	// Broadcast allows sending to ipv4 broadcast addresses
+	Broadcast bool
+}
+
+// Validate checks the values of options
+func (o *SocketOptions) Validate() error {
+	if o.TOS < 0 || o.TOS > 255 {
+		return fmt.Errorf("invalid tos %d, valid tos: 0-255", o.TOS)
+	}
+	if o.Mark < 0 {
+		return fmt.Errorf("invalid mark %d", o.Mark)
+	}
+	switch o.PMTUDisc {
+	case "", PMTUDiscDo, PMTUDiscDont, PMTUDiscWant, PMTUDiscProbe:
+	default:
+		return fmt.Errorf("invalid path mtu discovery %q, valid values: do, dont, want, probe", o.PMTUDisc)
+	}
+	return nil
+}
+
+// Apply sets the options to the socket of connection. ipv6 is the address family of socket.
+func (o *SocketOptions) Apply(c syscall.RawConn, ipv6 bool) error {
+	var err error
+	if cerr := c.Control(func(fd uintptr) {
+		err = o.ApplyFd(int(fd), ipv6)
+	}); cerr != nil {
+		return cerr
+	}
+	return err
+}
@@ -0,0 +1,68 @@
+//go:build darwin
+// +build darwin
+
+package utils
+
+import (
+	"fmt"
+	"net"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// ApplyFd sets the options to the socket. Firewall mark is not supported on darwin,
+// and path mtu discovery can only set or clear DF.
+func (o *SocketOptions) ApplyFd(fd int, ipv6 bool) error {
+	if err := o.Validate(); err != nil {
+		return err
+	}
+
+	if o.TOS > 0 {
+		level, opt := unix.IPPROTO_IP, unix.IP_TOS
+		if ipv6 {
+			level, opt = unix.IPPROTO_IPV6, unix.IPV6_TCLASS
+		}
+		if err := unix.SetsockoptInt(fd, level, opt, o.TOS); err != nil {
+			return os.NewSyscallError("setsockopt tos", err)
+		}
+	}
+	if o.Mark > 0 {
+		return fmt.Errorf("firewall mark is not supported")
+	}
+	if o.Broadcast && !ipv6 {
+		if err := unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_BROADCAST, 1); err != nil {
+			return os.NewSyscallError("setsockopt broadcast", err)
+		}
+	}
+	if o.BindDevice != "" {
+		intf, err := net.InterfaceByName(o.BindDevice)
+		if err != nil {
+			return err
+		}
+		level, opt := unix.IPPROTO_IP, unix.IP_BOUND_IF
+		if ipv6 {
+			level, opt = unix.IPPROTO_IPV6, unix.IPV6_BOUND_IF
+		}
+		if err := unix.SetsockoptInt(fd, level, opt, intf.Index); err != nil {
+			return os.NewSyscallError("setsockopt bind device", err)
+		}
+	}
+	switch o.PMTUDisc {
+	case PMTUDiscDo, PMTUDiscDont:
+		df := 0
+		if o.PMTUDisc == PMTUDiscDo {
+			df = 1
+		}
+		level, opt := unix.IPPROTO_IP, unix.IP_DONTFRAG
+		if ipv6 {
+			level, opt = unix.IPPROTO_IPV6, unix.IPV6_DONTFRAG
+		}
+		if err := unix.SetsockoptInt(fd, level, opt, df); err != nil {
+			return os.NewSyscallError("setsockopt dontfrag", err)
+		}
+	case PMTUDiscWant, PMTUDiscProbe:
+		return fmt.Errorf("path mtu discovery %q is not supported", o.PMTUDisc)
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+//go:build linux
+// +build linux
+
+package utils
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// ApplyFd sets the options to the socket
+func (o *SocketOptions) ApplyFd(fd int, ipv6 bool) error {
+	if err := o.Validate(); err != nil {
+		return err
+	}
+
+	if o.TOS > 0 {
+		level, opt := unix.IPPROTO_IP, unix.IP_TOS
+		if ipv6 {
+			level, opt = unix.IPPROTO_IPV6, unix.IPV6_TCLASS
+		}
+		if err := unix.SetsockoptInt(fd, level, opt, o.TOS); err != nil {
+			return os.NewSyscallError("setsockopt tos", err)
+		}
+	}
+	if o.Mark > 0 {
+		if err := unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_MARK, o.Mark); err != nil {
+			return os.NewSyscallError("setsockopt mark", err)
+		}
+	}
+	if o.Broadcast && !ipv6 {
+		if err := unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_BROADCAST, 1); err != nil {
+			return os.NewSyscallError("setsockopt broadcast", err)
+		}
+	}
+	if o.BindDevice != "" {
+		if err := unix.BindToDevice(fd, o.BindDevice); err != nil {
+			return os.NewSyscallError("setsockopt bind device", err)
+		}
+	}
+	if o.PMTUDisc != "" {
+		if err := o.setPMTUDisc(fd, ipv6); err != nil {
+			return os.NewSyscallError("setsockopt mtu discover", err)
+		}
+	}
+	return nil
+}
+
+func (o *SocketOptions) setPMTUDisc(fd int, ipv6 bool) error {
+	if ipv6 {
+		val := map[string]int{
+			PMTUDiscDo:    unix.IPV6_PMTUDISC_DO,
+			PMTUDiscDont:  unix.IPV6_PMTUDISC_DONT,
+			PMTUDiscWant:  unix.IPV6_PMTUDISC_WANT,
+			PMTUDiscProbe: unix.IPV6_PMTUDISC_PROBE,
+		}[o.PMTUDisc]
+		return unix.SetsockoptInt(fd, unix.IPPROTO_IPV6, unix.IPV6_MTU_DISCOVER, val)
+	}
+	val := map[string]int{
+		PMTUDiscDo:    unix.IP_PMTUDISC_DO,
+		PMTUDiscDont:  unix.IP_PMTUDISC_DONT,
+		PMTUDiscWant:  unix.IP_PMTUDISC_WANT,
+		PMTUDiscProbe: unix.IP_PMTUDISC_PROBE,
+	}[o.PMTUDisc]
+	return unix.SetsockoptInt(fd, unix.IPPROTO_IP, unix.IP_MTU_DISCOVER, val)
+}
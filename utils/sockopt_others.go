@@ -0,0 +1,17 @@
+//go:build !linux && !darwin
+// +build !linux,!darwin
+
+package utils
+
+import "fmt"
+
+// ApplyFd sets the options to the socket, none of them is supported on this platform
+func (o *SocketOptions) ApplyFd(fd int, ipv6 bool) error {
+	if err := o.Validate(); err != nil {
+		return err
+	}
+	if *o != (SocketOptions{}) {
+		return fmt.Errorf("socket options are not supported")
+	}
+	return nil
+}
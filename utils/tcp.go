@@ -0,0 +1,29 @@
+package utils
+
+// TCPProbeState is the result of a tcp half open probe
+type TCPProbeState int
+
+const (
+	// TCPProbeTimeout means nothing is received before timeout
+	TCPProbeTimeout TCPProbeState = iota
+	// TCPProbeOpen means syn-ack is received, the port is open
+	TCPProbeOpen
+	// TCPProbeClosed means rst is received, the port is closed
+	TCPProbeClosed
+	// TCPProbeUnreachable means the probe failed because of network error like
+	// host unreachable, the error is returned along with this state.
+	TCPProbeUnreachable
+)
+
+func (s TCPProbeState) String() string {
+	switch s {
+	case TCPProbeOpen:
+		return "open"
+	case TCPProbeClosed:
+		return "closed"
+	case TCPProbeUnreachable:
+		return "unreachable"
+	default:
+		return "timeout"
+	}
+}
@@ -0,0 +1,156 @@
+//go:build linux
+// +build linux
+
+package utils
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// pollInterval is the max time of each epoll wait, so the probe can be canceled by context
+const pollInterval = 100 * time.Millisecond
+
+// TCPHalfOpen sends syn to remote host, if remote host response with ack, then send rst.
+// so tcp connect can't be established. this ensures sending probe to remote host doesn't make
+// side effect. ttl is not set if it's zero, opts can be nil. sentAt is the time right before
+// connecting, it's zero if the syn isn't sent.
+func TCPHalfOpen(ctx context.Context, ip net.IP, srcPort, dstPort int, ttl uint8, timeout time.Duration, opts *SocketOptions) (state TCPProbeState, sentAt time.Time, err error) {
+	pollerFd, err := unix.EpollCreate1(unix.EPOLL_CLOEXEC)
+	if err != nil {
+		return TCPProbeTimeout, sentAt, err
+	}
+	defer unix.Close(pollerFd)
+
+	parsedAddr, family, err := parseSockaddr(ip, dstPort)
+	if err != nil {
+		return TCPProbeTimeout, sentAt, err
+	}
+	fd, err := unix.Socket(family, unix.SOCK_STREAM|unix.SOCK_CLOEXEC|unix.SOCK_NONBLOCK, 0)
+	if err != nil {
+		return TCPProbeTimeout, sentAt, err
+	}
+	defer unix.Close(fd)
+	if ttl > 0 {
+		if family == unix.AF_INET6 {
+			_ = unix.SetsockoptInt(fd, unix.IPPROTO_IPV6, unix.IPV6_UNICAST_HOPS, int(ttl))
+		} else {
+			_ = unix.SetsockoptInt(fd, unix.IPPROTO_IP, unix.IP_TTL, int(ttl))
+		}
+	}
+	_ = unix.SetsockoptInt(fd, unix.IPPROTO_TCP, unix.TCP_QUICKACK, 0)
+	_ = unix.SetsockoptLinger(fd, unix.SOL_SOCKET, unix.SO_LINGER, &unix.Linger{Onoff: 1, Linger: 0})
+	if opts != nil {
+		if err := opts.ApplyFd(fd, family == unix.AF_INET6); err != nil {
+			return TCPProbeTimeout, sentAt, err
+		}
+	}
+
+	if srcPort != 0 {
+		// the probes to different destinations can be bound to the same source port
+		_ = unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_REUSEADDR, 1)
+	}
+
+	if family == unix.AF_INET {
+		if err := unix.Bind(fd, &unix.SockaddrInet4{
+			Port: srcPort,
+		}); err != nil {
+			return TCPProbeTimeout, sentAt, err
+		}
+	} else {
+		if err := unix.Bind(fd, &unix.SockaddrInet6{
+			Port: srcPort,
+		}); err != nil {
+			return TCPProbeTimeout, sentAt, err
+		}
+	}
+
+	sentAt = time.Now()
+	switch serr := unix.Connect(fd, parsedAddr); serr {
+	case unix.EALREADY, unix.EINPROGRESS, unix.EINTR:
+		break
+	case unix.EISCONN, nil:
+		return TCPProbeOpen, sentAt, nil
+	case unix.ECONNREFUSED:
+		return TCPProbeClosed, sentAt, nil
+	case unix.EHOSTUNREACH, unix.ENETUNREACH:
+		return TCPProbeUnreachable, sentAt, serr
+	default:
+		return TCPProbeTimeout, sentAt, serr
+	}
+
+	// register events to epoll
+	var event unix.EpollEvent
+	event.Events = unix.EPOLLOUT | unix.EPOLLIN | unix.EPOLLET
+	event.Fd = int32(fd)
+	if err := unix.EpollCtl(pollerFd, unix.EPOLL_CTL_ADD, fd, &event); err != nil {
+		return TCPProbeTimeout, sentAt, err
+	}
+
+	// poll events
+	deadline := time.Now().Add(timeout)
+	var epollEvents [32]unix.EpollEvent
+	for {
+		if ctx.Err() != nil {
+			return TCPProbeTimeout, sentAt, nil
+		}
+		wait := time.Until(deadline)
+		if wait <= 0 {
+			return TCPProbeTimeout, sentAt, nil
+		}
+		if wait > pollInterval {
+			wait = pollInterval
+		}
+
+		n, err := unix.EpollWait(pollerFd, epollEvents[:], int(wait.Milliseconds()))
+		if err != nil {
+			if err == unix.EINTR {
+				continue
+			}
+			return TCPProbeTimeout, sentAt, err
+		}
+		if n > 0 {
+			break
+		}
+	}
+
+	soErr, err := unix.GetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_ERROR)
+	if err != nil {
+		return TCPProbeTimeout, sentAt, err
+	}
+	switch unix.Errno(soErr) {
+	case 0:
+		return TCPProbeOpen, sentAt, nil
+	case unix.ECONNREFUSED:
+		return TCPProbeClosed, sentAt, nil
+	default:
+		return TCPProbeUnreachable, sentAt, unix.Errno(soErr)
+	}
+}
+
+func parseSockaddr(ip net.IP, port int) (sAddr unix.Sockaddr, family int, err error) {
+	if ip4 := ip.To4(); ip4 != nil {
+		var addr4 [net.IPv4len]byte
+		copy(addr4[:], ip4)
+		sAddr = &unix.SockaddrInet4{Port: port, Addr: addr4}
+		family = unix.AF_INET
+		return
+	}
+
+	if ip16 := ip.To16(); ip16 != nil {
+		var addr16 [net.IPv6len]byte
+		copy(addr16[:], ip16)
+		sAddr = &unix.SockaddrInet6{Port: port, Addr: addr16}
+		family = unix.AF_INET6
+		return
+	}
+
+	err = &net.AddrError{
+		Err:  "unsupported address family",
+		Addr: ip.String(),
+	}
+	return
+}
@@ -0,0 +1,53 @@
+//go:build windows || darwin
+// +build windows darwin
+
+package utils
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"syscall"
+	"time"
+)
+
+// TCPHalfOpen falls back to a full tcp connect on this platform, the connection is
+// closed as soon as it is established. ttl is ignored, opts can be nil. sentAt is the time
+// right before dialing.
+func TCPHalfOpen(ctx context.Context, ip net.IP, srcPort, dstPort int, ttl uint8, timeout time.Duration, opts *SocketOptions) (state TCPProbeState, sentAt time.Time, err error) {
+	dialer := net.Dialer{
+		Timeout: timeout,
+		LocalAddr: &net.TCPAddr{
+			Port: srcPort,
+		},
+	}
+	if opts != nil {
+		dialer.Control = func(network, address string, c syscall.RawConn) error {
+			return opts.Apply(c, ip.To4() == nil)
+		}
+	}
+	sentAt = time.Now()
+	conn, err := dialer.DialContext(ctx, "tcp", (&net.TCPAddr{
+		IP:   ip,
+		Port: dstPort,
+	}).String())
+	if err != nil {
+		if errors.Is(err, syscall.ECONNREFUSED) {
+			return TCPProbeClosed, sentAt, nil
+		}
+		if errors.Is(err, os.ErrDeadlineExceeded) || errors.Is(err, context.Canceled) {
+			return TCPProbeTimeout, sentAt, nil
+		}
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			return TCPProbeTimeout, sentAt, nil
+		}
+		if errors.Is(err, syscall.EHOSTUNREACH) || errors.Is(err, syscall.ENETUNREACH) {
+			return TCPProbeUnreachable, sentAt, err
+		}
+		return TCPProbeTimeout, sentAt, err
+	}
+	conn.Close()
+	return TCPProbeOpen, sentAt, nil
+}